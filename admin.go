@@ -0,0 +1,65 @@
+package skycache
+
+import (
+	"encoding/json"
+	"net"
+	"net/http"
+)
+
+// 管理接口的请求体
+type adminRequest struct {
+	Peers []string `json:"peers"`
+}
+
+// 管理节点的接口，请求和响应都使用 JSON：
+//
+//	GET    返回当前的节点列表 {"peers": [...]}
+//	POST   增加节点，请求体为 {"peers": [...]}
+//	DELETE 移除节点，请求体同上，等待发往这些节点的请求完成后返回
+//	PUT    替换为给定的节点列表
+//
+// 修改成功后同样返回最新的节点列表
+func (p *Server) serveAdmin(w http.ResponseWriter, r *http.Request) {
+	authorize := p.adminAuth
+	if authorize == nil {
+		authorize = isLoopback
+	}
+	if !authorize(r) {
+		http.Error(w, "forbidden", http.StatusForbidden)
+		return
+	}
+
+	if r.Method != http.MethodGet {
+		var req adminRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		switch r.Method {
+		case http.MethodPost:
+			p.AddPeers(req.Peers...)
+		case http.MethodDelete:
+			p.RemovePeers(req.Peers...)
+		case http.MethodPut:
+			p.Set(req.Peers...)
+		default:
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		p.Log("admin %s %v", r.Method, req.Peers)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(adminRequest{Peers: p.Peers()})
+}
+
+// 请求是否来自本机，经过反向代理时 RemoteAddr 是代理的地址，应使用 WithAdmin 的 authorize
+func isLoopback(r *http.Request) bool {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return false
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
@@ -0,0 +1,208 @@
+package arc
+
+import (
+	"container/list"
+	"skycache/eviction"
+	"time"
+)
+
+var _ eviction.Cache = (*Cache)(nil)
+
+// ARC(Adaptive Replacement Cache)，按字节计算容量
+//
+// t1 保存只访问过一次的记录，t2 保存访问过多次的记录，
+// b1、b2 分别是 t1、t2 中被淘汰记录的"幽灵"，只保存 key 和大小。
+// 命中 b1 说明 t1 太小，命中 b2 说明 t2 太小，据此调整 t1 的目标大小 p，
+// 因此一次大范围扫描只会冲刷 t1，而不会把 t2 中的热点数据淘汰掉
+type Cache struct {
+	maxBytes  int64 //最大容量，为 0 则无上限
+	p         int64 //t1 的目标大小
+	t1, t2    *segment
+	b1, b2    *segment
+	cache     map[string]*list.Element //key -> t1/t2 中的元素
+	ghosts    map[string]*list.Element //key -> b1/b2 中的元素
+	OnEvicted eviction.OnEvicted       //回调函数，可选
+}
+
+type segment struct {
+	ll     *list.List //队首为最近访问
+	nBytes int64
+}
+
+type entry struct {
+	key    string
+	value  eviction.Value //幽灵记录为 nil
+	size   int64
+	expire time.Time //过期时间，零值表示永不过期
+	seg    *segment  //所在的链表
+}
+
+func newSegment() *segment {
+	return &segment{ll: list.New()}
+}
+
+func New(maxBytes int64, onEvicted eviction.OnEvicted) *Cache {
+	return &Cache{
+		maxBytes:  maxBytes,
+		t1:        newSegment(),
+		t2:        newSegment(),
+		b1:        newSegment(),
+		b2:        newSegment(),
+		cache:     make(map[string]*list.Element),
+		ghosts:    make(map[string]*list.Element),
+		OnEvicted: onEvicted,
+	}
+}
+
+// 查询，命中后移到 t2 的队首
+func (c *Cache) Get(key string) (eviction.Value, bool) {
+	if e, ok := c.cache[key]; ok {
+		kv := e.Value.(*entry)
+		if eviction.IsExpired(kv.expire, time.Now()) {
+			c.removeElement(e, eviction.Expired)
+			return nil, false
+		}
+		c.moveTo(e, c.t2)
+		return kv.value, true
+	}
+	return nil, false
+}
+
+func (c *Cache) Set(key string, value eviction.Value) {
+	c.SetWithTTL(key, value, 0)
+}
+
+func (c *Cache) SetWithTTL(key string, value eviction.Value, ttl time.Duration) {
+	expire := eviction.Deadline(ttl)
+	size := int64(len(key) + value.Len())
+
+	// 已缓存，视为一次访问
+	if e, ok := c.cache[key]; ok {
+		kv := e.Value.(*entry)
+		kv.seg.nBytes += size - kv.size
+		kv.value, kv.size, kv.expire = value, size, expire
+		c.moveTo(e, c.t2)
+		c.replace(false)
+		return
+	}
+
+	kv := &entry{key: key, value: value, size: size, expire: expire}
+
+	// 命中幽灵记录，调整 p 后放入 t2
+	if g, ok := c.ghosts[key]; ok {
+		inB2 := g.Value.(*entry).seg == c.b2
+		if inB2 {
+			c.p = max(c.p-size*max(c.b1.nBytes/max(c.b2.nBytes, 1), 1), 0)
+		} else {
+			c.p = min(c.p+size*max(c.b2.nBytes/max(c.b1.nBytes, 1), 1), c.maxBytes)
+		}
+		c.dropGhost(g)
+		c.push(kv, c.t2)
+		c.replace(inB2)
+		c.trimGhosts()
+		return
+	}
+
+	c.push(kv, c.t1)
+	c.replace(false)
+	c.trimGhosts()
+}
+
+// 限制幽灵记录的大小：t1+b1 不超过 maxBytes，全部链表不超过 2*maxBytes
+func (c *Cache) trimGhosts() {
+	for c.b1.ll.Len() > 0 && c.t1.nBytes+c.b1.nBytes > c.maxBytes {
+		c.dropGhost(c.b1.ll.Back())
+	}
+	for c.b2.ll.Len() > 0 && c.t1.nBytes+c.t2.nBytes+c.b1.nBytes+c.b2.nBytes > 2*c.maxBytes {
+		c.dropGhost(c.b2.ll.Back())
+	}
+}
+
+// 超出容量时，根据 p 从 t1 或 t2 淘汰记录，并放入对应的幽灵链表
+func (c *Cache) replace(inB2 bool) {
+	for c.maxBytes != 0 && c.t1.nBytes+c.t2.nBytes > c.maxBytes {
+		var e *list.Element
+		if c.t1.ll.Len() > 0 && (c.t1.nBytes > c.p || (inB2 && c.t1.nBytes == c.p) || c.t2.ll.Len() == 0) {
+			e = c.t1.ll.Back()
+		} else {
+			e = c.t2.ll.Back()
+		}
+		kv := e.Value.(*entry)
+		ghost := c.b1
+		if kv.seg == c.t2 {
+			ghost = c.b2
+		}
+		c.removeElement(e, eviction.Evicted)
+
+		// 幽灵记录只保留 key，但仍按原本的大小计算，用于和 maxBytes 比较
+		kv.value = nil
+		c.ghosts[kv.key] = c.push(kv, ghost)
+	}
+}
+
+func (c *Cache) push(kv *entry, seg *segment) *list.Element {
+	kv.seg = seg
+	seg.nBytes += kv.size
+	e := seg.ll.PushFront(kv)
+	if kv.value != nil {
+		c.cache[kv.key] = e
+	}
+	return e
+}
+
+func (c *Cache) moveTo(e *list.Element, seg *segment) {
+	kv := e.Value.(*entry)
+	if kv.seg == seg {
+		seg.ll.MoveToFront(e)
+		return
+	}
+	kv.seg.ll.Remove(e)
+	kv.seg.nBytes -= kv.size
+	c.push(kv, seg)
+}
+
+func (c *Cache) dropGhost(e *list.Element) {
+	kv := e.Value.(*entry)
+	kv.seg.ll.Remove(e)
+	kv.seg.nBytes -= kv.size
+	delete(c.ghosts, kv.key)
+}
+
+func (c *Cache) Remove(key string) {
+	if e, ok := c.cache[key]; ok {
+		c.removeElement(e, eviction.Removed)
+	}
+	if g, ok := c.ghosts[key]; ok {
+		c.dropGhost(g)
+	}
+}
+
+func (c *Cache) RemoveExpired() int {
+	now := time.Now()
+	n := 0
+	for _, e := range c.cache {
+		if eviction.IsExpired(e.Value.(*entry).expire, now) {
+			c.removeElement(e, eviction.Expired)
+			n++
+		}
+	}
+	return n
+}
+
+func (c *Cache) removeElement(e *list.Element, reason eviction.EvictReason) {
+	kv := e.Value.(*entry)
+	kv.seg.ll.Remove(e)
+	kv.seg.nBytes -= kv.size
+	delete(c.cache, kv.key)
+	if c.OnEvicted != nil {
+		c.OnEvicted(kv.key, kv.value, reason)
+	}
+}
+
+func (c *Cache) Len() int {
+	return len(c.cache)
+}
+
+func (c *Cache) Bytes() int64 {
+	return c.t1.nBytes + c.t2.nBytes
+}
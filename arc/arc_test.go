@@ -0,0 +1,90 @@
+package arc_test
+
+import (
+	"fmt"
+	"skycache/arc"
+	"testing"
+	"time"
+)
+
+type String string
+
+func (s String) Len() int {
+	return len(s)
+}
+
+func TestGet(t *testing.T) {
+	c := arc.New(0, nil)
+	c.Set("1", String("val1"))
+	if v, ok := c.Get("1"); !ok || string(v.(String)) != "val1" {
+		t.Fatalf("cache hit key 1 failed, expecetd val1, got %v", v)
+	}
+
+	if v, ok := c.Get("2"); ok {
+		t.Fatalf("cache hit key 2 failed, expected nil, got %v", v)
+	}
+}
+
+// 一次大范围的扫描不应该冲刷掉反复访问的热点数据
+func TestScanResistance(t *testing.T) {
+	c := arc.New(int64(100), nil)
+	hot := []string{"h1", "h2", "h3", "h4"}
+	for _, k := range hot {
+		c.Set(k, String("vv"))
+		c.Get(k)
+	}
+
+	for i := 0; i < 100; i++ {
+		c.Set(fmt.Sprintf("s%02d", i), String("vv"))
+	}
+
+	for _, k := range hot {
+		if _, ok := c.Get(k); !ok {
+			t.Fatalf("hot key %s was evicted by a scan", k)
+		}
+	}
+	if c.Bytes() > 100 {
+		t.Fatalf("cache exceeds maxBytes, %d bytes", c.Bytes())
+	}
+}
+
+// 命中幽灵记录的 key 会直接进入 t2
+func TestGhostHit(t *testing.T) {
+	c := arc.New(int64(40), nil)
+	// 5 个访问过两次的记录占满 t2 的一半
+	for i := 0; i < 5; i++ {
+		key := fmt.Sprintf("h%d", i)
+		c.Set(key, String("v1"))
+		c.Get(key)
+	}
+
+	c.Set("k1", String("v1"))
+	for i := 0; i < 5; i++ {
+		c.Set(fmt.Sprintf("s%d", i), String("v1"))
+	}
+	if _, ok := c.Get("k1"); ok {
+		t.Fatalf("k1 should be evicted")
+	}
+
+	c.Set("k1", String("v1"))
+	for i := 5; i < 10; i++ {
+		c.Set(fmt.Sprintf("s%d", i), String("v1"))
+	}
+	if _, ok := c.Get("k1"); !ok {
+		t.Fatalf("k1 should be kept in t2 after a ghost hit")
+	}
+}
+
+func TestSetWithTTL(t *testing.T) {
+	c := arc.New(0, nil)
+	c.SetWithTTL("k1", String("val1"), 10*time.Millisecond)
+	c.Set("k2", String("val2"))
+
+	time.Sleep(20 * time.Millisecond)
+	if n := c.RemoveExpired(); n != 1 {
+		t.Fatalf("RemoveExpired should remove 1 entry, but %d removed", n)
+	}
+	if _, ok := c.Get("k2"); !ok || c.Len() != 1 {
+		t.Fatalf("k2 should never expire")
+	}
+}
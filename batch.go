@@ -0,0 +1,204 @@
+package skycache
+
+import (
+	"context"
+	"errors"
+	"log"
+	pb "skycache/skycachepb"
+	"slices"
+	"sync"
+)
+
+// 可选接口，Getter 实现后，GetMulti 未命中的 key 可以一次性加载
+// 不存在的 key 直接从结果中省略，不要为它们返回 ErrNotFound；
+// 返回错误时，结果中已经加载的值仍会被使用，缺少的 key 不会被当作不存在
+type BatchGetter interface {
+	GetMulti(keys []string) (map[string][]byte, error)
+}
+
+// 批量获取，返回成功获取的 key-value，获取失败或不存在的 key 不在结果中，
+// err 为本地加载时遇到的第一个错误
+//
+// 本地命中的直接返回，未命中的按所属节点分组，每个节点只发起一次请求，
+// 剩下的(所属节点为自己，或者远程节点请求失败)交给 getter 加载
+func (g *Group) GetMulti(ctx context.Context, keys []string) (map[string]ByteView, error) {
+	result := make(map[string]ByteView, len(keys))
+	var misses []string
+	seen := make(map[string]bool, len(keys))
+	for _, key := range keys {
+		if key == "" {
+			return nil, errors.New("key is must")
+		}
+		if seen[key] {
+			continue
+		}
+		seen[key] = true
+
+		g.stats.gets.Add(1)
+		if e, stale, ok := g.lookupMain(key); ok && !stale {
+			result[key] = e.value
+		} else if v, ok := g.hotCache.get(key); ok {
+			result[key] = v
+		} else if g.negativeHit(key) {
+			continue // 确认不存在，从结果中省略
+		} else {
+			misses = append(misses, key)
+		}
+	}
+	g.stats.cacheHits.Add(int64(len(result)))
+	if len(misses) == 0 {
+		return result, nil
+	}
+	g.stats.loads.Add(int64(len(misses)))
+
+	// 按所属节点分组
+	var locals []string
+	byPeer := make(map[PeerGetter][]string)
+	for _, key := range misses {
+		if g.peers != nil {
+			if peer, ok := g.pickReader(ctx, key); ok {
+				byPeer[peer] = append(byPeer[peer], key)
+				continue
+			}
+		}
+		locals = append(locals, key)
+	}
+
+	var (
+		mu sync.Mutex
+		wg sync.WaitGroup
+	)
+	for peer, keys := range byPeer {
+		wg.Add(1)
+		go func(peer PeerGetter, keys []string) {
+			defer wg.Done()
+			values, err := g.getMultiFromPeer(ctx, peer, keys)
+
+			mu.Lock()
+			defer mu.Unlock()
+			if err != nil {
+				g.stats.peerErrors.Add(1)
+				log.Println("[GeeCache] Failed to get multi from peer", err)
+			}
+			g.stats.peerLoads.Add(int64(len(values)))
+			for key, v := range values {
+				result[key] = v
+			}
+			for _, key := range keys {
+				if _, ok := values[key]; ok {
+					continue
+				}
+				if err == nil {
+					// 所属节点没有出错却没有返回，说明 key 不存在，不需要再从本地加载
+					g.populateNegCache(key)
+				} else {
+					locals = append(locals, key)
+				}
+			}
+		}(peer, keys)
+	}
+	wg.Wait()
+
+	values, err := g.getMultiLocally(ctx, locals)
+	for key, v := range values {
+		result[key] = v
+	}
+	return result, err
+}
+
+func (g *Group) getMultiFromPeer(ctx context.Context, peer PeerGetter, keys []string) (map[string]ByteView, error) {
+	req := &pb.BatchRequest{
+		Group: g.name,
+		Keys:  keys,
+	}
+	resp := &pb.BatchResponse{}
+
+	// 部分加载失败时 resp 中仍有成功的部分
+	err := peer.GetMulti(ctx, req, resp)
+	values := make(map[string]ByteView, len(resp.Values))
+	for key, b := range resp.Values {
+		value := ByteView{b: b}
+		g.populateHotCache(key, value)
+		values[key] = value
+	}
+	return values, err
+}
+
+// 所属节点处理批量请求的响应，加载失败时仍然返回成功的部分，错误放在 Error 中，
+// 调用方只重新加载缺少的 key，而不是把整批当作失败或不存在
+func batchResponse(views map[string]ByteView, err error) *pb.BatchResponse {
+	out := &pb.BatchResponse{Values: make(map[string][]byte, len(views))}
+	for key, view := range views {
+		out.Values[key] = view.ByteSlice()
+	}
+	if err != nil {
+		out.Error = err.Error()
+	}
+	return out
+}
+
+// 加载所属节点为自己的 key，getter 实现了 BatchGetter 时只调用一次
+func (g *Group) getMultiLocally(ctx context.Context, keys []string) (map[string]ByteView, error) {
+	values := make(map[string]ByteView, len(keys))
+	if len(keys) == 0 {
+		return values, nil
+	}
+
+	if bg, ok := g.getter.(BatchGetter); ok {
+		// 一定不存在的 key 不交给 getter
+		if g.bloom != nil {
+			keys = slices.DeleteFunc(slices.Clone(keys), g.mayNotExist)
+			if len(keys) == 0 {
+				return values, nil
+			}
+		}
+		g.stats.loadsDeduped.Add(int64(len(keys)))
+		data, err := bg.GetMulti(keys)
+		// 整批的 ErrNotFound 不能说明每个 key 都不存在，缺少的 key 只是从结果中省略
+		if errors.Is(err, ErrNotFound) {
+			err = nil
+		}
+		if err != nil {
+			g.stats.localLoadErrs.Add(1)
+		}
+		g.stats.localLoads.Add(int64(len(data)))
+		for key, b := range data {
+			value := ByteView{b: cloneByte(b)}
+			g.polulateCache(key, value)
+			values[key] = value
+		}
+		// 没有出错时，缺少的 key 就是不存在的 key
+		if err == nil {
+			for _, key := range keys {
+				if _, ok := values[key]; !ok {
+					g.populateNegCache(key)
+				}
+			}
+		}
+		return values, err
+	}
+
+	var firstErr error
+	for _, key := range keys {
+		view, err, shared := g.loader.DoContext(ctx, key, func() (interface{}, error) {
+			defer logPanic(key)
+			// 只有执行加载的调用方需要 detach，等待者不创建，避免计时器泄漏
+			lctx, cancel := detach(ctx)
+			defer cancel()
+			g.stats.loadsDeduped.Add(1)
+			return g.getLocally(lctx, key)
+		})
+		if shared {
+			g.stats.loadsShared.Add(1)
+		}
+		if err != nil {
+			// 不存在的 key 直接从结果中省略，不算作错误
+			if firstErr == nil && !errors.Is(err, ErrNotFound) {
+				firstErr = err
+			}
+			continue
+		}
+		values[key] = view.(ByteView)
+	}
+	return values, firstErr
+}
@@ -0,0 +1,149 @@
+package bloom
+
+import (
+	"encoding/binary"
+	"errors"
+	"hash/fnv"
+	"math"
+	"sync"
+)
+
+// Bloom Filter，用于判断一个 key 是否"一定不存在"
+//
+// Test 返回 false 时 key 一定没有被 Add 过，返回 true 时可能存在误判。
+// 可以序列化后发送给其他节点，再用 Merge 合并
+type Filter struct {
+	mu   sync.RWMutex
+	m    uint64   //位数组的长度
+	k    uint32   //哈希函数的个数
+	bits []uint64 //位数组
+}
+
+// 序列化后的头部：m(8 字节) + k(4 字节)
+const headerLen = 12
+
+// 哈希函数个数的上限，误判率 1e-19 也只需要 63 个，
+// 更大的 k 只会让 Add 和 Test 在持有锁时循环很久
+const maxK = 64
+
+var ErrIncompatible = errors.New("bloom: filters have different sizes")
+
+// 根据预计的元素个数 n 和期望的误判率 fp 创建 Filter
+func New(n int, fp float64) *Filter {
+	if n < 1 {
+		n = 1
+	}
+	if fp <= 0 || fp >= 1 {
+		fp = 0.01
+	}
+	m := math.Ceil(-float64(n) * math.Log(fp) / (math.Ln2 * math.Ln2))
+	k := math.Round(m / float64(n) * math.Ln2)
+	return NewWithSize(uint64(m), uint32(max(k, 1)))
+}
+
+// 直接指定位数组的长度 m 和哈希函数的个数 k，k 限制在 [1, 64] 之间
+func NewWithSize(m uint64, k uint32) *Filter {
+	m = max(m, 64)
+	return &Filter{
+		m:    m,
+		k:    min(max(k, 1), maxK),
+		bits: make([]uint64, (m+63)/64),
+	}
+}
+
+// 使用双重哈希模拟 k 个哈希函数：h1 + i*h2
+func (f *Filter) locations(key string) (h1, h2 uint64) {
+	h := fnv.New64a()
+	h.Write([]byte(key))
+	sum := h.Sum64()
+	h1, h2 = sum&0xffffffff, sum>>32
+	// h2 为偶数时，位置可能只落在一部分位上
+	return h1, h2 | 1
+}
+
+func (f *Filter) Add(key string) {
+	h1, h2 := f.locations(key)
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for i := uint64(0); i < uint64(f.k); i++ {
+		pos := (h1 + i*h2) % f.m
+		f.bits[pos/64] |= 1 << (pos % 64)
+	}
+}
+
+// key 可能存在时返回 true，一定不存在时返回 false
+func (f *Filter) Test(key string) bool {
+	h1, h2 := f.locations(key)
+
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+	for i := uint64(0); i < uint64(f.k); i++ {
+		pos := (h1 + i*h2) % f.m
+		if f.bits[pos/64]&(1<<(pos%64)) == 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// 合并另一个 Filter，合并后包含两者的所有元素，两者的 m 和 k 必须相同
+func (f *Filter) Merge(other *Filter) error {
+	if f == other {
+		return nil
+	}
+	// 先复制 other，避免同时持有两把锁
+	other.mu.RLock()
+	m, k := other.m, other.k
+	bits := append([]uint64(nil), other.bits...)
+	other.mu.RUnlock()
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.m != m || f.k != k {
+		return ErrIncompatible
+	}
+	for i := range f.bits {
+		f.bits[i] |= bits[i]
+	}
+	return nil
+}
+
+// 实现 encoding.BinaryMarshaler
+func (f *Filter) MarshalBinary() ([]byte, error) {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+
+	b := make([]byte, headerLen+8*len(f.bits))
+	binary.BigEndian.PutUint64(b, f.m)
+	binary.BigEndian.PutUint32(b[8:], f.k)
+	for i, w := range f.bits {
+		binary.BigEndian.PutUint64(b[headerLen+8*i:], w)
+	}
+	return b, nil
+}
+
+// 实现 encoding.BinaryUnmarshaler，会覆盖 f 原有的内容
+func (f *Filter) UnmarshalBinary(data []byte) error {
+	if len(data) < headerLen {
+		return errors.New("bloom: data too short")
+	}
+	m := binary.BigEndian.Uint64(data)
+	k := binary.BigEndian.Uint32(data[8:])
+	// 字数由数据长度决定，m 必须正好需要这么多字，不能用 m 计算，否则 m 很大时会溢出
+	body := len(data) - headerLen
+	n := uint64(body / 8)
+	if m == 0 || k == 0 || k > maxK || body%8 != 0 || n == 0 || m > 64*n || m <= 64*(n-1) {
+		return errors.New("bloom: malformed data")
+	}
+
+	bits := make([]uint64, n)
+	for i := range bits {
+		bits[i] = binary.BigEndian.Uint64(data[headerLen+8*i:])
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.m, f.k, f.bits = m, k, bits
+	return nil
+}
@@ -0,0 +1,106 @@
+package bloom_test
+
+import (
+	"encoding/binary"
+	"skycache/bloom"
+	"strconv"
+	"testing"
+)
+
+func TestAddAndTest(t *testing.T) {
+	f := bloom.New(1000, 0.01)
+	for i := 0; i < 1000; i++ {
+		f.Add("key" + strconv.Itoa(i))
+	}
+
+	// 不能有假阴性
+	for i := 0; i < 1000; i++ {
+		if !f.Test("key" + strconv.Itoa(i)) {
+			t.Fatalf("key%d should be present", i)
+		}
+	}
+
+	// 误判率应接近 1%
+	fp := 0
+	for i := 0; i < 10000; i++ {
+		if f.Test("other" + strconv.Itoa(i)) {
+			fp++
+		}
+	}
+	if rate := float64(fp) / 10000; rate > 0.03 {
+		t.Fatalf("false positive rate too high: %v", rate)
+	}
+}
+
+func TestMarshal(t *testing.T) {
+	f := bloom.New(100, 0.01)
+	f.Add("Tom")
+	f.Add("Jack")
+
+	data, err := f.MarshalBinary()
+	if err != nil {
+		t.Fatal(err)
+	}
+	g := &bloom.Filter{}
+	if err := g.UnmarshalBinary(data); err != nil {
+		t.Fatal(err)
+	}
+	if !g.Test("Tom") || !g.Test("Jack") {
+		t.Fatal("keys lost after unmarshal")
+	}
+
+	if err := g.UnmarshalBinary(data[:len(data)-1]); err == nil {
+		t.Fatal("truncated data should be rejected")
+	}
+
+	// m、k 不合法或与数据长度不符时拒绝，而不是分配大量内存或 panic
+	for _, tc := range []struct {
+		m uint64
+		k uint32
+	}{{0, 7}, {128, 0}, {1<<64 - 1, 7}, {1<<64 - 32, 7}, {64, 7}, {193, 7}} {
+		bad := append([]byte(nil), data...)
+		binary.BigEndian.PutUint64(bad, tc.m)
+		binary.BigEndian.PutUint32(bad[8:], tc.k)
+		if err := g.UnmarshalBinary(bad); err == nil {
+			t.Fatalf("m=%d k=%d should be rejected", tc.m, tc.k)
+		}
+	}
+
+	// k 超过 64 时拒绝，否则 Add 和 Test 会在持有锁时循环很久
+	for _, k := range []uint32{65, 1<<32 - 1} {
+		bad := append([]byte(nil), data...)
+		binary.BigEndian.PutUint32(bad[8:], k)
+		if err := g.UnmarshalBinary(bad); err == nil {
+			t.Fatalf("k=%d should be rejected", k)
+		}
+	}
+	ok := append([]byte(nil), data...)
+	binary.BigEndian.PutUint32(ok[8:], 64)
+	if err := g.UnmarshalBinary(ok); err != nil {
+		t.Fatalf("k=64 should be accepted, got %v", err)
+	}
+
+	// m+63 溢出后字数为 0，只有头部的数据也必须拒绝
+	header := make([]byte, 12)
+	binary.BigEndian.PutUint64(header, 1<<64-1)
+	binary.BigEndian.PutUint32(header[8:], 7)
+	if err := g.UnmarshalBinary(header); err == nil {
+		t.Fatal("overflowing m should be rejected")
+	}
+}
+
+func TestMerge(t *testing.T) {
+	a, b := bloom.New(100, 0.01), bloom.New(100, 0.01)
+	a.Add("Tom")
+	b.Add("Jack")
+	if err := a.Merge(b); err != nil {
+		t.Fatal(err)
+	}
+	if !a.Test("Tom") || !a.Test("Jack") {
+		t.Fatal("merged filter should contain both keys")
+	}
+
+	if err := a.Merge(bloom.New(10000, 0.01)); err != bloom.ErrIncompatible {
+		t.Fatalf("expected ErrIncompatible, got %v", err)
+	}
+}
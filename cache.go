@@ -1,39 +1,304 @@
 package skycache
 
 import (
+	"skycache/arc"
+	"skycache/eviction"
+	"skycache/fifo"
+	"skycache/lfu"
 	"skycache/lru"
+	"skycache/tinylfu"
 	"sync"
+	"time"
 )
 
+// 默认的过期清理间隔
+const defaultJanitorInterval = time.Minute
+
+// 缓存淘汰策略
+type EvictionPolicy int
+
+const (
+	LRU     EvictionPolicy = iota //最近最少使用，默认策略
+	LFU                           //最不经常使用
+	FIFO                          //先进先出
+	ARC                           //自适应替换，兼顾访问时间和频次
+	TinyLFU                       //W-TinyLFU，适合扫描较多的场景
+)
+
+func (p EvictionPolicy) String() string {
+	switch p {
+	case LRU:
+		return "lru"
+	case LFU:
+		return "lfu"
+	case FIFO:
+		return "fifo"
+	case ARC:
+		return "arc"
+	case TinyLFU:
+		return "tinylfu"
+	}
+	return "unknown"
+}
+
+// 根据淘汰策略创建实际的 cache
+func newEvictionCache(policy EvictionPolicy, maxBytes int64, onEvicted eviction.OnEvicted) eviction.Cache {
+	switch policy {
+	case LFU:
+		return lfu.New(maxBytes, onEvicted)
+	case FIFO:
+		return fifo.New(maxBytes, onEvicted)
+	case ARC:
+		return arc.New(maxBytes, onEvicted)
+	case TinyLFU:
+		return tinylfu.New(maxBytes, onEvicted)
+	default:
+		return lru.New(maxBytes, onEvicted)
+	}
+}
+
 type cache struct {
-	mu         sync.Mutex //锁
-	lru        *lru.Cache //实际的 lru cache
-	cacheBytes int64      //容量
+	mu              sync.Mutex     //锁
+	store           eviction.Cache //实际的 cache，由 policy 决定淘汰策略
+	policy          EvictionPolicy //淘汰策略
+	cacheBytes      int64          //容量
+	janitorInterval time.Duration  //后台清理过期记录的间隔
+	janitorOnce     sync.Once      //janitor 只启动一次
+	stop            chan struct{}  //关闭后 janitor 退出
+	closed          bool           //close 之后不再启动 janitor
+	nget, nhit      int64          //查询和命中的次数
+	nevict, nexpire int64          //被淘汰和过期的次数
+	staleTTL        time.Duration  //记录过期后继续保留的时间，为 0 则过期即删除
+}
+
+// cache 中保存的记录，带上写入时间和逻辑上的过期时间
+// 设置了 staleTTL 时，记录实际保存到 expire + staleTTL
+type entry struct {
+	value   ByteView
+	created time.Time
+	expire  time.Time //零值表示永不过期
+}
+
+func (e entry) Len() int {
+	return e.value.Len()
+}
+
+// 已经过期，只能作为旧值使用
+func (e entry) expired(now time.Time) bool {
+	return eviction.IsExpired(e.expire, now)
+}
+
+// 存在的时间超过了 ttl 的 fraction，需要提前刷新
+func (e entry) needsRefresh(now time.Time, fraction float64) bool {
+	if fraction <= 0 || e.expire.IsZero() {
+		return false
+	}
+	ttl := e.expire.Sub(e.created)
+	return now.Sub(e.created) >= time.Duration(float64(ttl)*fraction)
+}
+
+// Group 中的两种 cache
+type CacheType int
+
+const (
+	MainCache     CacheType = iota + 1 //所属节点为自己的数据
+	HotCache                           //从远程节点获取的热点数据的副本
+	NegativeCache                      //确认不存在的 key
+)
+
+// cache 的统计信息
+type CacheStats struct {
+	Bytes       int64 //当前占用的字节数
+	Items       int64 //当前的记录数
+	Gets        int64
+	Hits        int64
+	Evictions   int64 //因容量不足被淘汰
+	Expirations int64 //因过期被清除
 }
 
-func (c *cache) set(key string, value ByteView) {
+func (c *cache) set(key string, value ByteView, ttl time.Duration) {
 	c.mu.Lock()
 	defer c.mu.Unlock()
+	c.setLocked(key, value, ttl)
+}
 
-	//延迟初始化
-	if c.lru == nil {
-		c.lru = lru.New(c.cacheBytes, nil)
+// 只有 key 的记录还是 old 时才写入 value，并沿用 old 的 ttl
+// 用于刷新，避免用旧的加载结果覆盖期间写入的新值
+func (c *cache) replace(key string, value ByteView, old entry) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if !c.unchanged(key, old) {
+		return false
 	}
-	c.lru.Set(key, value)
+	var ttl time.Duration
+	if !old.expire.IsZero() {
+		ttl = old.expire.Sub(old.created)
+	}
+	c.setLocked(key, value, ttl)
+	return true
 }
 
-func (c *cache) get(key string) (ByteView, bool) {
-	// ! mu 不能只 lock 读锁，因为 lru.Get 存在移动链表的操作，会修改它
+// 只有 key 的记录还是 old 时才删除
+func (c *cache) removeIf(key string, old entry) bool {
 	c.mu.Lock()
 	defer c.mu.Unlock()
 
-	if c.lru == nil {
+	if !c.unchanged(key, old) {
+		return false
+	}
+	c.store.Remove(key)
+	return true
+}
+
+// key 的记录是否还是 old，调用前需要持有 mu
+func (c *cache) unchanged(key string, old entry) bool {
+	if c.store == nil {
+		return false
+	}
+	v, ok := c.store.Get(key)
+	return ok && v.(entry).created.Equal(old.created)
+}
+
+func (c *cache) setLocked(key string, value ByteView, ttl time.Duration) {
+	//延迟初始化
+	if c.store == nil {
+		c.store = newEvictionCache(c.policy, c.cacheBytes, c.onEvicted)
+	}
+	now := time.Now()
+	e := entry{value: value, created: now}
+	if ttl > 0 {
+		e.expire = now.Add(ttl)
+		ttl += c.staleTTL
+	}
+	c.store.SetWithTTL(key, e, ttl)
+
+	// 出现了会过期的记录，才需要后台清理
+	if ttl > 0 && !c.closed {
+		c.janitorOnce.Do(func() {
+			c.stop = make(chan struct{})
+			go c.janitor(c.stop)
+		})
+	}
+}
+
+func (c *cache) get(key string) (ByteView, bool) {
+	e, ok := c.getEntry(key)
+	if !ok || e.expired(time.Now()) {
 		// * 不要返回 nil, false，会出问题的
 		return ByteView{}, false
 	}
+	return e.value, true
+}
+
+// 返回 key 的记录，包括已过期但还在 staleTTL 内的记录，已过期的不计入命中
+func (c *cache) getEntry(key string) (entry, bool) {
+	// ! mu 不能只 lock 读锁，因为 Get 可能存在移动链表的操作，会修改它
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.nget++
+	if c.store == nil {
+		return entry{}, false
+	}
+
+	v, ok := c.store.Get(key)
+	if !ok {
+		return entry{}, false
+	}
+	e := v.(entry)
+	if !e.expired(time.Now()) {
+		c.nhit++
+	}
+	return e, true
+}
+
+// 由 store 调用，此时已经持有 mu
+func (c *cache) onEvicted(key string, value eviction.Value, reason eviction.EvictReason) {
+	switch reason {
+	case eviction.Evicted:
+		c.nevict++
+	case eviction.Expired:
+		c.nexpire++
+	}
+}
+
+func (c *cache) remove(key string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.store != nil {
+		c.store.Remove(key)
+	}
+}
+
+func (c *cache) stats() CacheStats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	stats := CacheStats{
+		Gets:        c.nget,
+		Hits:        c.nhit,
+		Evictions:   c.nevict,
+		Expirations: c.nexpire,
+	}
+	if c.store != nil {
+		stats.Bytes = c.store.Bytes()
+		stats.Items = int64(c.store.Len())
+	}
+	return stats
+}
+
+// 定期清除过期记录，使 nBytes 保持准确
+func (c *cache) janitor(stop <-chan struct{}) {
+	interval := c.janitorInterval
+	if interval <= 0 {
+		interval = defaultJanitorInterval
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			c.removeExpired()
+		case <-stop:
+			return
+		}
+	}
+}
+
+// 停止 janitor，之后过期记录只在访问时删除
+func (c *cache) close() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.closed {
+		return
+	}
+	c.closed = true
+	if c.stop != nil {
+		close(c.stop)
+	}
+}
+
+func (c *cache) removeExpired() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.store == nil {
+		return 0
+	}
+	return c.store.RemoveExpired()
+}
+
+// 当前占用的字节数
+func (c *cache) bytes() int64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
 
-	if v, ok := c.lru.Get(key); ok {
-		return v.(ByteView), ok
+	if c.store == nil {
+		return 0
 	}
-	return ByteView{}, false
+	return c.store.Bytes()
 }
@@ -1,50 +1,250 @@
 package skycache
 
 import (
+	"bytes"
+	"context"
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
 	"net/url"
 	pb "skycache/skycachepb"
+	"strings"
+	"sync"
+	"time"
 
 	"google.golang.org/protobuf/proto"
 )
 
 // 为 cache 实现访问远程节点的能力
 
-var _ PeerGetter = (*Client)(nil)
+var _ PeerLeaser = (*Client)(nil)
+
+// 请求头，携带调用方剩余的超时时间，远程节点据此设置自己的截止时间
+const timeoutHeader = "X-Skycache-Timeout"
 
 // 实现 Getter 接口，使用 HTTP 访问 url 获得对应的资源
 type Client struct {
-	target string // ip:port + basePath
+	target     string       // ip:port + basePath
+	latency    *histogram   // 记录 Get 的耗时，可以为 nil
+	httpClient *http.Client // 每个节点单独的连接池，为 nil 则使用 http.DefaultClient
+	mu         sync.Mutex
+	inflight   int           // 正在进行的请求数
+	idle       chan struct{} // drain 等待时创建，inflight 归零时关闭
+	health     *breaker      // 记录请求的结果，可以为 nil
+	peer       string        // 节点地址，上报负载时使用
+	loads      loadReporter  // 上报正在进行的请求数，可以为 nil
+}
+
+// 需要知道节点负载的 Placement 实现此接口，例如 consistenthash.Bounded
+type loadReporter interface {
+	Inc(node string)
+	Done(node string)
+}
+
+// 创建使用独立连接池的 Client，节点被移除时可以单独关闭它的连接
+func newClient(target string, latency *histogram) *Client {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	return &Client{
+		target:     target,
+		latency:    latency,
+		httpClient: &http.Client{Transport: transport},
+	}
+}
+
+// 熔断器是否放行下一个请求，半开状态下会占用探测名额
+func (h *Client) allow() bool {
+	return h.health == nil || h.health.allow()
+}
+
+// 等待正在进行的请求完成，最多等待 timeout，然后关闭空闲连接
+// 调用前应保证不会再有新的请求，返回是否所有请求都已完成
+func (h *Client) drain(timeout time.Duration) bool {
+	h.mu.Lock()
+	var idle chan struct{}
+	if h.inflight > 0 {
+		if h.idle == nil {
+			h.idle = make(chan struct{})
+		}
+		idle = h.idle
+	}
+	h.mu.Unlock()
+
+	drained := true
+	if idle != nil {
+		timer := time.NewTimer(timeout)
+		defer timer.Stop()
+		select {
+		case <-idle:
+		case <-timer.C:
+			drained = false
+		}
+	}
+	if h.httpClient != nil {
+		h.httpClient.CloseIdleConnections()
+	}
+	return drained
+}
+
+func (h *Client) begin() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.inflight++
+}
+
+func (h *Client) end() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.inflight--
+	if h.inflight == 0 && h.idle != nil {
+		close(h.idle)
+		h.idle = nil
+	}
 }
 
 // 和 远程节点通信，远程节点进入 ServeHTTP 响应
-func (h *Client) Get(in *pb.Request, out *pb.Response) error {
-	u := fmt.Sprintf(
-		"%v%v/%v",
-		h.target,
-		url.QueryEscape(in.Group),
-		url.QueryEscape(in.Key),
-	)
-	res, err := http.Get(u)
+func (h *Client) Get(ctx context.Context, in *pb.Request, out *pb.Response) error {
+	if h.latency != nil {
+		defer func(start time.Time) { h.latency.observe(time.Since(start)) }(time.Now())
+	}
+	body, err := h.do(ctx, http.MethodGet, in.Group, in.Key, "", nil)
 	if err != nil {
 		return err
 	}
-	defer res.Body.Close()
 
-	if res.StatusCode != http.StatusOK {
-		return fmt.Errorf("server returned: %v", res.Status)
+	if err = proto.Unmarshal(body, out); err != nil {
+		return fmt.Errorf("decoding response body: %v", err)
+	}
+	if out.NotFound {
+		return &PeerError{Addr: h.target, Err: ErrNotFound}
 	}
 
-	bytes, err := io.ReadAll(res.Body)
+	return nil
+}
+
+// 使用 POST <basePath>/<group> 批量获取
+func (h *Client) GetMulti(ctx context.Context, in *pb.BatchRequest, out *pb.BatchResponse) error {
+	body, err := proto.Marshal(in)
+	if err != nil {
+		return err
+	}
+	body, err = h.post(ctx, in.Group, body)
 	if err != nil {
 		return err
 	}
 
-	if err = proto.Unmarshal(bytes, out); err != nil {
+	if err = proto.Unmarshal(body, out); err != nil {
 		return fmt.Errorf("decoding response body: %v", err)
 	}
+	if out.Error != "" {
+		return &PeerError{Addr: h.target, Err: errors.New(out.Error)}
+	}
+	return nil
+}
+
+// 使用 PUT 在远程节点写入
+func (h *Client) Set(ctx context.Context, in *pb.SetRequest) error {
+	body, err := proto.Marshal(in)
+	if err != nil {
+		return err
+	}
+	_, err = h.do(ctx, http.MethodPut, in.Group, in.Key, "", body)
+	return err
+}
+
+// 使用 POST <basePath>/<group>/<key> 申请或归还加载租约
+func (h *Client) Lease(ctx context.Context, in *pb.LeaseRequest, out *pb.LeaseResponse) error {
+	body, err := proto.Marshal(in)
+	if err != nil {
+		return err
+	}
+	body, err = h.do(ctx, http.MethodPost, in.Group, in.Key, "", body)
+	if err != nil {
+		return err
+	}
 
+	if err = proto.Unmarshal(body, out); err != nil {
+		return fmt.Errorf("decoding response body: %v", err)
+	}
 	return nil
 }
+
+// 使用 DELETE 在远程节点删除
+func (h *Client) Remove(ctx context.Context, in *pb.Request) error {
+	_, err := h.do(ctx, http.MethodDelete, in.Group, in.Key, "", nil)
+	return err
+}
+
+// 使用 DELETE 并带上 invalidate 参数，只删除远程节点本地的副本
+func (h *Client) Invalidate(ctx context.Context, in *pb.Request) error {
+	_, err := h.do(ctx, http.MethodDelete, in.Group, in.Key, "invalidate=true", nil)
+	return err
+}
+
+func (h *Client) do(ctx context.Context, method, group, key, query string, body []byte) ([]byte, error) {
+	u := fmt.Sprintf(
+		"%v%v/%v",
+		h.target,
+		url.QueryEscape(group),
+		url.QueryEscape(key),
+	)
+	if query != "" {
+		u += "?" + query
+	}
+	return h.send(ctx, method, u, body)
+}
+
+func (h *Client) post(ctx context.Context, group string, body []byte) ([]byte, error) {
+	return h.send(ctx, http.MethodPost, h.target+url.QueryEscape(group), body)
+}
+
+// ctx 结束时请求会被中断，ctx 的截止时间通过 timeoutHeader 传给远程节点
+// 返回的错误都是 *PeerError，远程节点返回的错误根据状态码重建
+func (h *Client) send(ctx context.Context, method, u string, body []byte) ([]byte, error) {
+	h.begin()
+	defer h.end()
+	if h.loads != nil {
+		h.loads.Inc(h.peer)
+		defer h.loads.Done(h.peer)
+	}
+
+	b, err := h.roundTrip(ctx, method, u, body)
+	if h.health != nil {
+		// 调用方取消或超时导致的失败，不能说明节点是否健康
+		if err != nil && ctx.Err() != nil {
+			h.health.abort()
+		} else {
+			h.health.record(err)
+		}
+	}
+	if err != nil {
+		return nil, &PeerError{Addr: h.target, Err: err}
+	}
+	return b, nil
+}
+
+func (h *Client) roundTrip(ctx context.Context, method, u string, body []byte) ([]byte, error) {
+	req, err := http.NewRequestWithContext(ctx, method, u, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	if deadline, ok := ctx.Deadline(); ok {
+		req.Header.Set(timeoutHeader, time.Until(deadline).String())
+	}
+	client := h.httpClient
+	if client == nil {
+		client = http.DefaultClient
+	}
+	res, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		msg, _ := io.ReadAll(res.Body)
+		return nil, errorFromHTTP(res.StatusCode, strings.TrimSpace(string(msg)))
+	}
+
+	return io.ReadAll(res.Body)
+}
@@ -0,0 +1,135 @@
+package consistenthash
+
+import (
+	"math"
+	"sync"
+)
+
+// Consistent Hashing with Bounded Loads (Mirrokni et al., 2016)
+//
+// 在哈希环的基础上限制每个节点的负载不超过平均负载的 (1+epsilon) 倍，
+// 所属节点负载已满时顺时针交给下一个未满的节点，避免热点 key 压垮单个节点。
+// 负载由调用方通过 Inc/Done 上报，例如正在进行的请求数
+//
+// 结果取决于本机观察到的负载，不同机器、同一机器的不同时刻可能选出不同的节点，
+// 不满足 Placement 的确定性要求，只能用于读请求的路由，所属节点由 Ring 决定
+type Bounded struct {
+	ring    *HashMap
+	epsilon float64
+
+	mu    sync.Mutex
+	loads map[string]int //节点 -> 当前负载
+	total int
+}
+
+// epsilon 越小越均衡，但 key 离开所属节点的概率越大，常用 0.25
+func NewBounded(replicas uint, epsilon float64, fn Hash) *Bounded {
+	return &Bounded{
+		ring:    New(replicas, fn),
+		epsilon: epsilon,
+		loads:   make(map[string]int),
+	}
+}
+
+func (b *Bounded) Add(nodes ...string) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for _, node := range nodes {
+		if _, ok := b.loads[node]; !ok {
+			b.loads[node] = 0
+			b.ring.Add(node)
+		}
+	}
+}
+
+func (b *Bounded) Remove(node string) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if load, ok := b.loads[node]; ok {
+		b.total -= load
+		delete(b.loads, node)
+		b.ring.Remove(node)
+	}
+}
+
+// 环上第一个未满的节点
+func (b *Bounded) Get(key string) (string, bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	capacity := b.capacity()
+	owner := ""
+	b.ring.walk(key, func(node string) bool {
+		if b.loads[node] < capacity {
+			owner = node
+			return false
+		}
+		return true
+	})
+	return owner, owner != ""
+}
+
+// 先按环上的顺序访问未满的节点，再访问已满的节点
+func (b *Bounded) Walk(key string, fn func(node string) bool) {
+	b.mu.Lock()
+	capacity := b.capacity()
+	loads := make(map[string]int, len(b.loads))
+	for node, load := range b.loads {
+		loads[node] = load
+	}
+	b.mu.Unlock()
+
+	var free, full []string
+	b.ring.walk(key, func(node string) bool {
+		if loads[node] < capacity {
+			free = append(free, node)
+		} else {
+			full = append(full, node)
+		}
+		return true
+	})
+	for _, node := range append(free, full...) {
+		if !fn(node) {
+			return
+		}
+	}
+}
+
+// 不考虑负载的哈希环，与 Bounded 的节点相同，所有机器上结果一致，用来决定 key 的所属节点
+func (b *Bounded) Ring() Placement {
+	return b.ring
+}
+
+// 节点开始处理一个请求
+func (b *Bounded) Inc(node string) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if _, ok := b.loads[node]; ok {
+		b.loads[node]++
+		b.total++
+	}
+}
+
+// 节点处理完一个请求
+func (b *Bounded) Done(node string) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if load, ok := b.loads[node]; ok && load > 0 {
+		b.loads[node]--
+		b.total--
+	}
+}
+
+// 返回节点当前的负载
+func (b *Bounded) Load(node string) int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.loads[node]
+}
+
+// 每个节点的负载上限，算上即将分配的这一个
+func (b *Bounded) capacity() int {
+	if len(b.loads) == 0 {
+		return 0
+	}
+	return int(math.Ceil((1 + b.epsilon) * float64(b.total+1) / float64(len(b.loads))))
+}
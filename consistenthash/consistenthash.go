@@ -2,6 +2,7 @@ package consistenthash
 
 import (
 	"hash/crc32"
+	"slices"
 	"sort"
 	"strconv"
 	"sync"
@@ -9,12 +10,14 @@ import (
 
 type Hash func(data []byte) uint32
 
+// 哈希环，每个真实节点对应 replicas 个虚拟节点
 type HashMap struct {
-	hash     Hash           //哈希函数
-	replicas uint           //节点放大倍数，即一个节点 -> r 个虚拟节点
-	keys     []int          //有序的虚拟节点 哈希值，定义为int 是方便后面 sort
-	keyMap   map[int]string //虚拟节点 -> 真实节点
-	mu       sync.Mutex     //锁，控制并发
+	hash     Hash             //哈希函数
+	replicas uint             //节点放大倍数，即一个节点 -> r 个虚拟节点
+	keys     []int            //有序的虚拟节点 哈希值，定义为int 是方便后面 sort
+	keyMap   map[int][]string //虚拟节点 -> 真实节点，哈希冲突时按名称排序，第一个生效
+	weights  map[string]int   //真实节点 -> 权重，虚拟节点数为 replicas*weight
+	mu       sync.Mutex       //锁，控制并发
 }
 
 // 返回一个 一致哈希结构，若 fn 为 nil，则使用 crc编码
@@ -26,33 +29,70 @@ func New(replicas uint, fn Hash) *HashMap {
 	return &HashMap{
 		hash:     fn,
 		replicas: replicas,
-		keyMap:   make(map[int]string),
+		keyMap:   make(map[int][]string),
+		weights:  make(map[string]int),
 	}
 }
 
-// 增加节点，keys是节点名称，暂时忽略 哈希冲突
+// 增加节点，keys是节点名称，权重均为 1，已存在的节点会被忽略
+//
+// 两个虚拟节点哈希冲突时，该位置属于名称较小的节点，另一个节点只是少了一个虚拟节点；
+// 前者被去掉后位置还给后者。因此结果只取决于节点集合，与加入和去掉的顺序无关
 func (h *HashMap) Add(keys ...string) {
 	h.mu.Lock()
 	defer h.mu.Unlock()
 
 	for _, key := range keys {
-		for i := 0; i < int(h.replicas); i++ {
-			hashKey := h.hash([]byte(strconv.Itoa(i) + key))
-			h.keys = append(h.keys, int(hashKey))
-			h.keyMap[int(hashKey)] = key
+		if _, ok := h.weights[key]; !ok {
+			h.add(key, 1)
 		}
 	}
 	sort.Ints([]int(h.keys))
 }
 
-// 然后 哈希环上 key 对应的 节点名称，key 是资源名称
-func (h *HashMap) Get(key string) string {
+// 增加一个带权重的节点，虚拟节点数随权重线性增加，分到的 key 也大致与权重成正比
+// 例如内存 64G 的节点权重为 8，内存 8G 的节点权重为 1
+// 节点已存在时更新它的权重，weight <= 0 时等同于 Remove
+func (h *HashMap) AddWeighted(key string, weight int) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if _, ok := h.weights[key]; ok {
+		h.remove(key)
+	}
+	if weight > 0 {
+		h.add(key, weight)
+		sort.Ints([]int(h.keys))
+	}
+}
+
+// 返回节点的权重，节点不存在时返回 0
+func (h *HashMap) Weight(key string) int {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.weights[key]
+}
+
+func (h *HashMap) add(key string, weight int) {
+	for i := 0; i < int(h.replicas)*weight; i++ {
+		hashKey := int(h.hash([]byte(strconv.Itoa(i) + key)))
+		owners, ok := h.keyMap[hashKey]
+		if !ok {
+			h.keys = append(h.keys, hashKey)
+		}
+		idx := sort.SearchStrings(owners, key)
+		h.keyMap[hashKey] = slices.Insert(owners, idx, key)
+	}
+	h.weights[key] = weight
+}
+
+// 然后 哈希环上 key 对应的 节点名称，key 是资源名称，哈希环为空时返回 false
+func (h *HashMap) Get(key string) (string, bool) {
 	h.mu.Lock()
 	defer h.mu.Unlock()
 
-	// ? maybe panic is better ?
 	if len(h.keys) == 0 {
-		panic("empty hashMap keys")
+		return "", false
 	}
 
 	hashKey := h.hash([]byte(key))
@@ -64,20 +104,84 @@ func (h *HashMap) Get(key string) string {
 	})
 
 	// 成环，要对 len(h.keys) 取模，即 idx = n 时，选中节点0
-	return h.keyMap[h.keys[idx%len(h.keys)]]
+	return h.keyMap[h.keys[idx%len(h.keys)]][0], true
+}
+
+// 从 key 在哈希环上的位置开始顺时针遍历，每个真实节点只访问一次，fn 返回 false 时停止
+// 第一个访问的节点就是 Get 返回的节点，之后是它的后继节点
+func (h *HashMap) Walk(key string, fn func(node string) bool) {
+	// 先复制出遍历顺序，fn 中可能会访问 HashMap
+	var nodes []string
+	h.walk(key, func(node string) bool {
+		nodes = append(nodes, node)
+		return true
+	})
+	for _, node := range nodes {
+		if !fn(node) {
+			return
+		}
+	}
 }
 
-// 去掉该节点
+// 持有锁遍历，fn 中不能访问 HashMap
+func (h *HashMap) walk(key string, fn func(node string) bool) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if len(h.keys) == 0 {
+		return
+	}
+	hashKey := int(h.hash([]byte(key)))
+	idx := sort.Search(len(h.keys), func(i int) bool {
+		return h.keys[i] >= hashKey
+	})
+
+	seen := make(map[string]bool)
+	for i := 0; i < len(h.keys) && len(seen) < len(h.weights); i++ {
+		node := h.keyMap[h.keys[(idx+i)%len(h.keys)]][0]
+		if !seen[node] {
+			seen[node] = true
+			if !fn(node) {
+				return
+			}
+		}
+	}
+}
+
+// 返回 key 在哈希环上的前 n 个不同的真实节点，第一个就是 Get 返回的节点
+// 节点数不足 n 时返回所有节点
+func (h *HashMap) GetN(key string, n int) []string {
+	return GetN(h, key, n)
+}
+
+// 去掉该节点，节点不存在时什么也不做
 func (h *HashMap) Remove(key string) {
 	h.mu.Lock()
 	defer h.mu.Unlock()
+	h.remove(key)
+}
 
-	for i := 0; i < int(h.replicas); i++ {
-		hashKey := h.hash([]byte(strconv.Itoa(i) + key))
-		idx := sort.SearchInts(h.keys, int(hashKey))
+func (h *HashMap) remove(key string) {
+	weight, ok := h.weights[key]
+	if !ok {
+		return
+	}
+	for i := 0; i < int(h.replicas)*weight; i++ {
+		hashKey := int(h.hash([]byte(strconv.Itoa(i) + key)))
+		owners := h.keyMap[hashKey]
+		j := sort.SearchStrings(owners, key)
+		if j == len(owners) || owners[j] != key {
+			continue
+		}
+		if len(owners) > 1 {
+			//还有其他节点在这个位置上，位置保留
+			h.keyMap[hashKey] = slices.Delete(owners, j, j+1)
+			continue
+		}
 		//去掉该虚拟节点
-		h.keys = append(h.keys[:idx], h.keys[idx+1:]...)
-		delete(h.keyMap, int(hashKey))
+		delete(h.keyMap, hashKey)
+		if idx := sort.SearchInts(h.keys, hashKey); idx < len(h.keys) && h.keys[idx] == hashKey {
+			h.keys = slices.Delete(h.keys, idx, idx+1)
+		}
 	}
-
+	delete(h.weights, key)
 }
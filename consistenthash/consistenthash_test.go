@@ -1,8 +1,13 @@
 package consistenthash
 
 import (
+	"hash/crc32"
+	"reflect"
+	"slices"
+	"sort"
 	"strconv"
 	"testing"
+	"testing/quick"
 )
 
 func TestHashing(t *testing.T) {
@@ -30,7 +35,7 @@ func TestHashing(t *testing.T) {
 	}
 
 	for k, v := range testCases {
-		name := hashM.Get(k)
+		name, _ := hashM.Get(k)
 		if name != v {
 			t.Fatalf("Asking for %s, expected %s, got %s", k, v, name)
 		}
@@ -43,9 +48,192 @@ func TestHashing(t *testing.T) {
 	testCases["27"] = "8"
 
 	for k, v := range testCases {
-		name := hashM.Get(k)
+		name, _ := hashM.Get(k)
 		if name != v {
 			t.Fatalf("Asking for %s, expected %s, got %s", k, v, name)
 		}
 	}
 }
+
+func TestWalk(t *testing.T) {
+	hashM := New(3, func(key []byte) uint32 {
+		hashed, _ := strconv.Atoi(string(key))
+		return uint32(hashed)
+	})
+	// 2, 4, 6, 12, 14, 16, 22, 24, 26
+	hashM.Add("6", "4", "2")
+
+	var nodes []string
+	hashM.Walk("11", func(node string) bool {
+		nodes = append(nodes, node)
+		return true
+	})
+	if len(nodes) != 3 || nodes[0] != "2" || nodes[1] != "4" || nodes[2] != "6" {
+		t.Fatalf("unexpected walk order %v", nodes)
+	}
+
+	// 提前停止
+	nodes = nodes[:0]
+	hashM.Walk("25", func(node string) bool {
+		nodes = append(nodes, node)
+		return len(nodes) < 2
+	})
+	if len(nodes) != 2 || nodes[0] != "6" || nodes[1] != "2" {
+		t.Fatalf("unexpected walk order %v", nodes)
+	}
+
+	New(3, nil).Walk("key", func(string) bool {
+		t.Fatal("empty ring should not visit any node")
+		return false
+	})
+}
+
+func TestGetN(t *testing.T) {
+	hashM := New(3, func(key []byte) uint32 {
+		hashed, _ := strconv.Atoi(string(key))
+		return uint32(hashed)
+	})
+	// 2, 4, 6, 12, 14, 16, 22, 24, 26
+	hashM.Add("6", "4", "2")
+
+	testCases := map[string][]string{
+		"11": {"2", "4"},
+		"23": {"4", "6"},
+		"27": {"2", "4"},
+	}
+	for k, v := range testCases {
+		if got := hashM.GetN(k, 2); !reflect.DeepEqual(got, v) {
+			t.Fatalf("Asking for %s, expected %v, got %v", k, v, got)
+		}
+		if owner, _ := hashM.Get(k); hashM.GetN(k, 1)[0] != owner {
+			t.Fatalf("GetN(%s, 1) should agree with Get", k)
+		}
+	}
+
+	if got := hashM.GetN("11", 5); len(got) != 3 {
+		t.Fatalf("expected all 3 nodes, got %v", got)
+	}
+}
+
+func TestAddWeighted(t *testing.T) {
+	hashM := New(50, nil)
+	weights := map[string]int{"small": 1, "medium": 2, "large": 8}
+	total := 0
+	for node, w := range weights {
+		hashM.AddWeighted(node, w)
+		total += w
+	}
+	if len(hashM.keys) != 50*total {
+		t.Fatalf("expected %d virtual nodes, got %d", 50*total, len(hashM.keys))
+	}
+
+	// 每个节点分到的 key 比例应与权重接近
+	const n = 100000
+	counts := make(map[string]int)
+	for i := 0; i < n; i++ {
+		node, _ := hashM.Get("key" + strconv.Itoa(i))
+		counts[node]++
+	}
+	for node, w := range weights {
+		want := float64(w) / float64(total)
+		got := float64(counts[node]) / n
+		if got < want*0.7 || got > want*1.3 {
+			t.Errorf("%s: weight share %.3f, key share %.3f", node, want, got)
+		}
+	}
+
+	// 修改权重，虚拟节点随之变化
+	hashM.AddWeighted("large", 1)
+	if hashM.Weight("large") != 1 || len(hashM.keys) != 50*4 {
+		t.Fatalf("unexpected weight %d with %d virtual nodes", hashM.Weight("large"), len(hashM.keys))
+	}
+	hashM.Remove("medium")
+	if hashM.Weight("medium") != 0 || len(hashM.keys) != 50*2 {
+		t.Fatalf("remove left %d virtual nodes", len(hashM.keys))
+	}
+}
+
+// 只有 64 个取值的哈希函数，几乎每个虚拟节点都会冲突
+func collidingHash(data []byte) uint32 {
+	return crc32.ChecksumIEEE(data) % 64
+}
+
+// 检查内部状态是否一致，返回 false 表示有问题
+func consistent(h *HashMap) bool {
+	if !sort.IntsAreSorted(h.keys) || len(h.keys) != len(h.keyMap) {
+		return false
+	}
+	for i, k := range h.keys {
+		owners := h.keyMap[k]
+		if len(owners) == 0 || !sort.StringsAreSorted(owners) || (i > 0 && h.keys[i-1] == k) {
+			return false
+		}
+		for _, node := range owners {
+			if h.weights[node] == 0 {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+func TestEmpty(t *testing.T) {
+	hashM := New(3, nil)
+	if node, ok := hashM.Get("key"); ok || node != "" {
+		t.Fatalf("empty ring returned %q", node)
+	}
+	hashM.Remove("unknown")
+	hashM.Add("a")
+	hashM.Remove("unknown")
+	if node, ok := hashM.Get("key"); !ok || node != "a" || len(hashM.keys) != 3 {
+		t.Fatalf("removing an unknown node changed the ring: %q %v", node, hashM.keys)
+	}
+	hashM.Remove("a")
+	if _, ok := hashM.Get("key"); ok || len(hashM.keys) != 0 || len(hashM.keyMap) != 0 {
+		t.Fatal("ring should be empty after removing the last node")
+	}
+}
+
+// 随机增删节点后，结果只取决于剩下的节点集合，与操作顺序无关
+func TestAddRemoveProperty(t *testing.T) {
+	property := func(ops []uint8) bool {
+		hashM := New(10, collidingHash)
+		alive := make(map[string]bool)
+		for _, op := range ops {
+			node := "node" + strconv.Itoa(int(op%16))
+			if op&0x80 != 0 {
+				hashM.Remove(node)
+				delete(alive, node)
+			} else {
+				hashM.Add(node)
+				alive[node] = true
+			}
+			if !consistent(hashM) {
+				return false
+			}
+		}
+
+		// 按排序后的顺序重新构建，内部状态应完全相同
+		var nodes []string
+		for node := range alive {
+			nodes = append(nodes, node)
+		}
+		sort.Strings(nodes)
+		fresh := New(10, collidingHash)
+		fresh.Add(nodes...)
+		if !slices.Equal(hashM.keys, fresh.keys) || !reflect.DeepEqual(hashM.keyMap, fresh.keyMap) {
+			return false
+		}
+
+		for i := 0; i < 100; i++ {
+			node, ok := hashM.Get("key" + strconv.Itoa(i))
+			if ok != (len(alive) > 0) || (ok && !alive[node]) {
+				return false
+			}
+		}
+		return true
+	}
+	if err := quick.Check(property, &quick.Config{MaxCount: 500}); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,81 @@
+package consistenthash
+
+import (
+	"hash/crc32"
+	"slices"
+	"sync"
+)
+
+// Jump Consistent Hash (Lamping & Veach, 2014)
+//
+// 不需要虚拟节点，几乎没有额外内存，分布非常均匀。
+// 但它只能把 key 映射到编号 [0, n) 的桶，节点按名称排序后编号：
+// 新节点排在最后时(例如 cache-00 ... cache-09 之后加入 cache-10)只迁移 1/n 的 key，
+// 从中间加入或去掉节点时，排在它后面的节点编号都会变化，迁移量会大很多
+type Jump struct {
+	hash  Hash
+	mu    sync.Mutex
+	nodes []string //按名称排序
+}
+
+func NewJump(fn Hash) *Jump {
+	if fn == nil {
+		fn = crc32.ChecksumIEEE
+	}
+	return &Jump{hash: fn}
+}
+
+func (j *Jump) Add(nodes ...string) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	for _, node := range nodes {
+		if i, ok := slices.BinarySearch(j.nodes, node); !ok {
+			j.nodes = slices.Insert(j.nodes, i, node)
+		}
+	}
+}
+
+func (j *Jump) Remove(node string) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	if i, ok := slices.BinarySearch(j.nodes, node); ok {
+		j.nodes = slices.Delete(j.nodes, i, i+1)
+	}
+}
+
+func (j *Jump) Get(key string) (string, bool) {
+	hashKey := mix(uint64(j.hash([]byte(key))))
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	if len(j.nodes) == 0 {
+		return "", false
+	}
+	return j.nodes[jump(hashKey, len(j.nodes))], true
+}
+
+// 依次模拟去掉已访问的节点，备选节点就是所属节点被 Remove 后 key 的新位置
+func (j *Jump) Walk(key string, fn func(node string) bool) {
+	j.mu.Lock()
+	nodes := slices.Clone(j.nodes)
+	j.mu.Unlock()
+
+	hashKey := mix(uint64(j.hash([]byte(key))))
+	for len(nodes) > 0 {
+		i := jump(hashKey, len(nodes))
+		if !fn(nodes[i]) {
+			return
+		}
+		nodes = slices.Delete(nodes, i, i+1)
+	}
+}
+
+// 论文中的算法，返回 [0, n) 中的桶编号
+func jump(key uint64, n int) int {
+	var b, j int64 = -1, 0
+	for j < int64(n) {
+		b = j
+		key = key*2862933555777941757 + 1
+		j = int64(float64(b+1) * (float64(int64(1)<<31) / float64((key>>33)+1)))
+	}
+	return int(b)
+}
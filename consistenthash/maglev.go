@@ -0,0 +1,160 @@
+package consistenthash
+
+import (
+	"hash/crc32"
+	"slices"
+	"sync"
+)
+
+// Maglev 一致性哈希(Google, 2016)
+//
+// 每个节点按自己的 (offset, skip) 排列轮流填充一张大小为质数的查找表，查询只需要一次取模。
+// 各节点在表中的槽位数几乎相同，节点变化时大部分 key 不迁移，但比哈希环多少有一些额外迁移。
+// 节点变化时需要重建整张表，适合节点变化不频繁的场景
+type Maglev struct {
+	hash  Hash
+	size  uint64 //查找表大小，质数，节点数超过它时使用大于节点数的质数
+	mu    sync.Mutex
+	nodes []string //按名称排序，保证所有机器上的表相同
+	table []int    //槽位 -> nodes 的下标
+}
+
+// 默认的查找表大小，需要是质数，建议至少为节点数的 100 倍
+const DefaultMaglevSize = 65537
+
+// size 不是质数时向上取到下一个质数，为 0 时使用 DefaultMaglevSize
+// 表的大小不是质数时，填充可能永远找不到空槽位
+func NewMaglev(size uint64, fn Hash) *Maglev {
+	if fn == nil {
+		fn = crc32.ChecksumIEEE
+	}
+	if size == 0 {
+		size = DefaultMaglevSize
+	}
+	return &Maglev{hash: fn, size: nextPrime(size)}
+}
+
+// 返回 >= n 的最小质数
+func nextPrime(n uint64) uint64 {
+	if n <= 2 {
+		return 2
+	}
+	if n%2 == 0 {
+		n++
+	}
+	for ; ; n += 2 {
+		prime := true
+		for d := uint64(3); d*d <= n; d += 2 {
+			if n%d == 0 {
+				prime = false
+				break
+			}
+		}
+		if prime {
+			return n
+		}
+	}
+}
+
+func (m *Maglev) Add(nodes ...string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	changed := false
+	for _, node := range nodes {
+		if i, ok := slices.BinarySearch(m.nodes, node); !ok {
+			m.nodes = slices.Insert(m.nodes, i, node)
+			changed = true
+		}
+	}
+	if changed {
+		m.populate()
+	}
+}
+
+func (m *Maglev) Remove(node string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if i, ok := slices.BinarySearch(m.nodes, node); ok {
+		m.nodes = slices.Delete(m.nodes, i, i+1)
+		m.populate()
+	}
+}
+
+func (m *Maglev) Get(key string) (string, bool) {
+	hashKey := mix(uint64(m.hash([]byte(key))))
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if len(m.table) == 0 {
+		return "", false
+	}
+	return m.nodes[m.table[hashKey%uint64(len(m.table))]], true
+}
+
+// 从 key 的槽位开始依次访问后面的槽位，每个节点只访问一次
+func (m *Maglev) Walk(key string, fn func(node string) bool) {
+	hashKey := mix(uint64(m.hash([]byte(key))))
+	m.mu.Lock()
+	var nodes []string
+	if size := uint64(len(m.table)); size > 0 {
+		seen := make([]bool, len(m.nodes))
+		for i := uint64(0); i < size && len(nodes) < len(m.nodes); i++ {
+			idx := m.table[(hashKey+i)%size]
+			if !seen[idx] {
+				seen[idx] = true
+				nodes = append(nodes, m.nodes[idx])
+			}
+		}
+	}
+	m.mu.Unlock()
+
+	for _, node := range nodes {
+		if !fn(node) {
+			return
+		}
+	}
+}
+
+// 重建查找表，见论文中的 Pseudocode 1
+func (m *Maglev) populate() {
+	n := len(m.nodes)
+	if n == 0 {
+		m.table = nil
+		return
+	}
+
+	// 只由 size 和节点数决定，保证所有机器上的表相同
+	size := m.size
+	if size < uint64(n) {
+		size = nextPrime(uint64(n))
+	}
+
+	offsets := make([]uint64, n)
+	skips := make([]uint64, n)
+	next := make([]uint64, n)
+	for i, node := range m.nodes {
+		h := mix(uint64(m.hash([]byte(node))))
+		offsets[i] = (h >> 32) % size
+		skips[i] = (h&0xffffffff)%(size-1) + 1
+	}
+
+	table := make([]int, size)
+	for i := range table {
+		table[i] = -1
+	}
+	for filled := uint64(0); ; {
+		for i := 0; i < n; i++ {
+			c := (offsets[i] + next[i]*skips[i]) % size
+			for table[c] >= 0 {
+				next[i]++
+				c = (offsets[i] + next[i]*skips[i]) % size
+			}
+			table[c] = i
+			next[i]++
+			filled++
+			if filled == size {
+				m.table = table
+				return
+			}
+		}
+	}
+}
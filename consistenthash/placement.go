@@ -0,0 +1,61 @@
+package consistenthash
+
+// Placement 决定 key 由哪个节点负责
+//
+// 除了哈希环 HashMap，还提供了 Jump、Rendezvous、Maglev 和 Bounded 几种实现，
+// 它们在均衡性、节点变化时 key 的迁移量、查询速度上各有取舍，见 placement_test.go 中的对比。
+// 同样的节点集合在所有机器上必须得到同样的结果，与节点加入的顺序无关。
+// Bounded 除外，它的结果随负载变化，只用于分配读请求，所属节点由它的 Ring 决定
+type Placement interface {
+	// 增加节点，已存在的节点会被忽略
+	Add(nodes ...string)
+	// 去掉节点
+	Remove(node string)
+	// 返回 key 所属的节点，没有节点时返回 ("", false)
+	Get(key string) (string, bool)
+	// 按优先级遍历所有节点，每个节点只访问一次，fn 返回 false 时停止
+	// 第一个访问的节点就是 Get 返回的节点，之后是所属节点不可用时的备选节点
+	Walk(key string, fn func(node string) bool)
+}
+
+// 支持权重的 Placement，分到的 key 大致与权重成正比
+type Weighted interface {
+	Placement
+	// 节点已存在时更新它的权重
+	AddWeighted(node string, weight int)
+	// 返回节点的权重，节点不存在时返回 0
+	Weight(node string) int
+}
+
+var (
+	_ Weighted  = (*HashMap)(nil)
+	_ Weighted  = (*Rendezvous)(nil)
+	_ Placement = (*Jump)(nil)
+	_ Placement = (*Maglev)(nil)
+	_ Placement = (*Bounded)(nil)
+)
+
+// 返回 key 的前 n 个不同节点，第一个就是 Get 返回的节点
+// 节点数不足 n 时返回所有节点
+func GetN(p Placement, key string, n int) []string {
+	nodes := make([]string, 0, n)
+	if n <= 0 {
+		return nodes
+	}
+	p.Walk(key, func(node string) bool {
+		nodes = append(nodes, node)
+		return len(nodes) < n
+	})
+	return nodes
+}
+
+// 把 32 位的哈希值打散到 64 位(splitmix64)
+// crc32 是线性的，直接拼接后哈希会让不同节点的结果高度相关
+func mix(x uint64) uint64 {
+	x ^= x >> 30
+	x *= 0xbf58476d1ce4e5b9
+	x ^= x >> 27
+	x *= 0x94d049bb133111eb
+	x ^= x >> 31
+	return x
+}
@@ -0,0 +1,217 @@
+package consistenthash
+
+import (
+	"fmt"
+	"strconv"
+	"testing"
+)
+
+// 对比各实现的均衡性和节点变化时的迁移量，go test -v -run TestPlacement 查看结果
+
+var placements = []struct {
+	name string
+	new  func() Placement
+}{
+	{"ring", func() Placement { return New(50, nil) }},
+	{"jump", func() Placement { return NewJump(nil) }},
+	{"rendezvous", func() Placement { return NewRendezvous(nil) }},
+	{"maglev", func() Placement { return NewMaglev(0, nil) }},
+	{"bounded", func() Placement { return NewBounded(50, 0.25, nil) }},
+}
+
+const (
+	testNodes = 10
+	testKeys  = 100000
+)
+
+func nodeName(i int) string {
+	return fmt.Sprintf("node-%02d", i)
+}
+
+func assign(p Placement) []string {
+	owners := make([]string, testKeys)
+	for i := range owners {
+		owners[i], _ = p.Get("key" + strconv.Itoa(i))
+	}
+	return owners
+}
+
+func moved(before, after []string) float64 {
+	n := 0
+	for i := range before {
+		if before[i] != after[i] {
+			n++
+		}
+	}
+	return float64(n) / float64(len(before))
+}
+
+func TestPlacementBalance(t *testing.T) {
+	for _, tt := range placements {
+		p := tt.new()
+		for i := 0; i < testNodes; i++ {
+			p.Add(nodeName(i))
+		}
+
+		counts := make(map[string]int)
+		for _, owner := range assign(p) {
+			counts[owner]++
+		}
+		max := 0
+		for _, c := range counts {
+			if c > max {
+				max = c
+			}
+		}
+		// 最大负载 / 平均负载，越接近 1 越均衡
+		ratio := float64(max) / (testKeys / testNodes)
+		t.Logf("%-10s nodes=%d max/mean=%.3f", tt.name, len(counts), ratio)
+		if len(counts) != testNodes || ratio > 1.5 {
+			t.Errorf("%s: unbalanced %v", tt.name, counts)
+		}
+	}
+}
+
+func TestPlacementMovement(t *testing.T) {
+	for _, tt := range placements {
+		p := tt.new()
+		for i := 0; i < testNodes; i++ {
+			p.Add(nodeName(i))
+		}
+		before := assign(p)
+
+		// 在最后加入一个节点，理想情况下迁移 1/(n+1)
+		p.Add(nodeName(testNodes))
+		added := moved(before, assign(p))
+		p.Remove(nodeName(testNodes))
+		if restored := moved(before, assign(p)); restored != 0 {
+			t.Errorf("%s: %.3f of keys did not return after removing the new node", tt.name, restored)
+		}
+
+		// 去掉中间的一个节点，理想情况下迁移 1/n
+		p.Remove(nodeName(testNodes / 2))
+		after := assign(p)
+		removed := moved(before, after)
+
+		t.Logf("%-10s add moved=%.3f remove moved=%.3f", tt.name, added, removed)
+		if added > 2.0/(testNodes+1) {
+			t.Errorf("%s: adding a node moved %.3f of keys", tt.name, added)
+		}
+		// jump 从中间去掉节点会改变后面所有节点的编号，不做要求
+		if tt.name != "jump" && removed > 2.0/testNodes {
+			t.Errorf("%s: removing a node moved %.3f of keys", tt.name, removed)
+		}
+		for i := range before {
+			if before[i] != nodeName(testNodes/2) && before[i] != after[i] && tt.name != "jump" && tt.name != "maglev" {
+				t.Fatalf("%s: key%d moved from %s although its node is alive", tt.name, i, before[i])
+			}
+		}
+	}
+}
+
+// 所属节点不可用时，Walk 的下一个节点应该在节点间均匀分布
+func TestPlacementWalk(t *testing.T) {
+	for _, tt := range placements {
+		p := tt.new()
+		for i := 0; i < testNodes; i++ {
+			p.Add(nodeName(i))
+		}
+		for i := 0; i < 100; i++ {
+			key := "key" + strconv.Itoa(i)
+			nodes := GetN(p, key, testNodes+1)
+			if owner, _ := p.Get(key); len(nodes) != testNodes || nodes[0] != owner {
+				t.Fatalf("%s: unexpected walk %v", tt.name, nodes)
+			}
+			seen := make(map[string]bool)
+			for _, node := range nodes {
+				if seen[node] {
+					t.Fatalf("%s: %s visited twice", tt.name, node)
+				}
+				seen[node] = true
+			}
+		}
+
+		if node, ok := tt.new().Get("key"); ok || node != "" {
+			t.Fatalf("%s: empty placement returned %q", tt.name, node)
+		}
+	}
+}
+
+func TestBounded(t *testing.T) {
+	b := NewBounded(50, 0.25, nil)
+	b.Add("a", "b", "c")
+	owner, _ := b.Get("hot")
+
+	// 所属节点负载已满时交给下一个节点
+	for i := 0; i < 10; i++ {
+		b.Inc(owner)
+	}
+	if next, _ := b.Get("hot"); next == owner {
+		t.Fatalf("overloaded %s should be skipped", owner)
+	}
+	if nodes := GetN(b, "hot", 3); nodes[2] != owner {
+		t.Fatalf("overloaded node should be visited last: %v", nodes)
+	}
+
+	for i := 0; i < 10; i++ {
+		b.Done(owner)
+	}
+	if next, _ := b.Get("hot"); b.Load(owner) != 0 || next != owner {
+		t.Fatal("key should return to its owner once the load drops")
+	}
+}
+
+func TestMaglevSize(t *testing.T) {
+	for _, c := range []struct{ in, want uint64 }{{1, 2}, {2, 2}, {4, 5}, {100, 101}, {65537, 65537}} {
+		if got := nextPrime(c.in); got != c.want {
+			t.Fatalf("nextPrime(%d) = %d, want %d", c.in, got, c.want)
+		}
+	}
+
+	// 大小不是质数或小于节点数时，仍然能建表，并且每个节点都有槽位
+	for _, size := range []uint64{1, 4, 100} {
+		m := NewMaglev(size, nil)
+		nodes := []string{"a", "b", "c", "d", "e", "f", "g"}
+		m.Add(nodes...)
+		var walked []string
+		m.Walk("key", func(node string) bool {
+			walked = append(walked, node)
+			return true
+		})
+		if len(walked) != len(nodes) {
+			t.Fatalf("size %d: walk should visit all nodes, got %v", size, walked)
+		}
+	}
+}
+
+func TestRendezvousWeighted(t *testing.T) {
+	r := NewRendezvous(nil)
+	r.AddWeighted("small", 1)
+	r.AddWeighted("large", 3)
+
+	counts := make(map[string]int)
+	for i := 0; i < testKeys; i++ {
+		node, _ := r.Get("key" + strconv.Itoa(i))
+		counts[node]++
+	}
+	share := float64(counts["large"]) / testKeys
+	if share < 0.7 || share > 0.8 {
+		t.Fatalf("large node should own about 75%% of keys, got %.3f", share)
+	}
+}
+
+func BenchmarkGet(b *testing.B) {
+	for _, tt := range placements {
+		for _, n := range []int{10, 100} {
+			p := tt.new()
+			for i := 0; i < n; i++ {
+				p.Add(nodeName(i))
+			}
+			b.Run(fmt.Sprintf("%s/nodes=%d", tt.name, n), func(b *testing.B) {
+				for i := 0; i < b.N; i++ {
+					p.Get("key" + strconv.Itoa(i))
+				}
+			})
+		}
+	}
+}
@@ -0,0 +1,121 @@
+package consistenthash
+
+import (
+	"hash/crc32"
+	"math"
+	"sort"
+	"sync"
+)
+
+// Rendezvous hashing，又称最高随机权重(HRW)
+//
+// 对每个节点计算 score(node, key)，分数最高的节点负责该 key。
+// 去掉节点时只迁移该节点的 key，不需要虚拟节点，但每次查询是 O(n) 的，适合节点不多的集群。
+// 带权重时使用 score = weight / -ln(u)，分到的 key 与权重成正比
+type Rendezvous struct {
+	hash    Hash
+	mu      sync.Mutex
+	weights map[string]int //节点 -> 权重
+	hashes  map[string]uint64
+}
+
+func NewRendezvous(fn Hash) *Rendezvous {
+	if fn == nil {
+		fn = crc32.ChecksumIEEE
+	}
+	return &Rendezvous{
+		hash:    fn,
+		weights: make(map[string]int),
+		hashes:  make(map[string]uint64),
+	}
+}
+
+func (r *Rendezvous) Add(nodes ...string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, node := range nodes {
+		if _, ok := r.weights[node]; !ok {
+			r.add(node, 1)
+		}
+	}
+}
+
+// weight <= 0 时等同于 Remove
+func (r *Rendezvous) AddWeighted(node string, weight int) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if weight <= 0 {
+		r.remove(node)
+		return
+	}
+	r.add(node, weight)
+}
+
+func (r *Rendezvous) add(node string, weight int) {
+	r.weights[node] = weight
+	r.hashes[node] = mix(uint64(r.hash([]byte(node))))
+}
+
+func (r *Rendezvous) Weight(node string) int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.weights[node]
+}
+
+func (r *Rendezvous) Remove(node string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.remove(node)
+}
+
+func (r *Rendezvous) remove(node string) {
+	delete(r.weights, node)
+	delete(r.hashes, node)
+}
+
+func (r *Rendezvous) Get(key string) (string, bool) {
+	hashKey := mix(uint64(r.hash([]byte(key))))
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	best, bestScore := "", 0.0
+	for node, w := range r.weights {
+		score := r.score(node, w, hashKey)
+		if best == "" || score > bestScore || (score == bestScore && node < best) {
+			best, bestScore = node, score
+		}
+	}
+	return best, best != ""
+}
+
+// 按分数从高到低遍历
+func (r *Rendezvous) Walk(key string, fn func(node string) bool) {
+	hashKey := mix(uint64(r.hash([]byte(key))))
+	r.mu.Lock()
+	nodes := make([]string, 0, len(r.weights))
+	scores := make(map[string]float64, len(r.weights))
+	for node, w := range r.weights {
+		nodes = append(nodes, node)
+		scores[node] = r.score(node, w, hashKey)
+	}
+	r.mu.Unlock()
+
+	sort.Slice(nodes, func(i, j int) bool {
+		if scores[nodes[i]] != scores[nodes[j]] {
+			return scores[nodes[i]] > scores[nodes[j]]
+		}
+		return nodes[i] < nodes[j]
+	})
+	for _, node := range nodes {
+		if !fn(node) {
+			return
+		}
+	}
+}
+
+func (r *Rendezvous) score(node string, weight int, hashKey uint64) float64 {
+	h := mix(r.hashes[node] ^ hashKey)
+	// 取高 53 位映射到 (0, 1)
+	u := (float64(h>>11) + 0.5) / (1 << 53)
+	return float64(weight) / -math.Log(u)
+}
@@ -0,0 +1,95 @@
+package discovery
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"slices"
+	"strings"
+	"time"
+)
+
+// 节点发现，返回当前集群中所有节点的地址，例如 "http://10.0.0.1:8001"
+//
+// 与 Server 配合使用：
+//
+//	d, _ := discovery.Parse("file:peers.json")
+//	go discovery.Watch(ctx, d, 5*time.Second, func(peers []string) { server.Set(peers...) })
+type Discoverer interface {
+	Peers(ctx context.Context) ([]string, error)
+}
+
+// 固定的节点列表
+type Static []string
+
+func (s Static) Peers(ctx context.Context) ([]string, error) {
+	return s, nil
+}
+
+// 根据字符串创建 Discoverer，便于作为命令行参数：
+//
+//	file:<path>                  从文件读取，见 File
+//	dns:<name>                   查询 SRV 记录，见 DNS
+//	http://a:8001,http://b:8001  固定的节点列表
+func Parse(spec string) (Discoverer, error) {
+	switch {
+	case strings.HasPrefix(spec, "file:"):
+		return &File{Path: strings.TrimPrefix(spec, "file:")}, nil
+	case strings.HasPrefix(spec, "dns:"):
+		return &DNS{Name: strings.TrimPrefix(spec, "dns:")}, nil
+	case spec == "":
+		return nil, fmt.Errorf("discovery: empty spec")
+	}
+
+	var peers Static
+	for _, peer := range strings.Split(spec, ",") {
+		if peer = strings.TrimSpace(peer); peer != "" {
+			peers = append(peers, peer)
+		}
+	}
+	return peers, nil
+}
+
+// 查询结果为空时返回的错误，空列表通常是文件写了一半或配置错误，
+// 交给 Server.Set 会把所有节点(包括自己)都移除
+var ErrNoPeers = errors.New("discovery: no peers")
+
+// 每隔 interval 查询一次 d，节点列表变化时调用 onChange，直到 ctx 结束
+// 第一次查询的结果一定会通知；查询失败或结果为空时保留上一次的结果
+func Watch(ctx context.Context, d Discoverer, interval time.Duration, onChange func(peers []string)) {
+	var last []string
+	notified := false
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		peers, err := d.Peers(ctx)
+		if err == nil && len(peers) == 0 {
+			err = ErrNoPeers
+		}
+		if err != nil {
+			log.Printf("[Discovery] %v", err)
+		} else {
+			peers = normalize(peers)
+			if !notified || !slices.Equal(peers, last) {
+				log.Printf("[Discovery] peers %v", peers)
+				onChange(peers)
+				last, notified = peers, true
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// 排序并去重，便于比较
+func normalize(peers []string) []string {
+	peers = slices.Clone(peers)
+	slices.Sort(peers)
+	return slices.Compact(peers)
+}
@@ -0,0 +1,170 @@
+package discovery
+
+import (
+	"context"
+	"net"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+func TestFile(t *testing.T) {
+	want := []string{"http://a:8001", "http://b:8001"}
+	tests := map[string]string{
+		"peers.json":  `["http://a:8001", "http://b:8001"]`,
+		"object.json": `{"peers": ["http://a:8001", "http://b:8001"]}`,
+		"peers.yaml":  "- http://a:8001\n- http://b:8001\n",
+		"object.yml":  "peers:\n  - http://a:8001\n  - http://b:8001\n",
+		"peers.txt":   "# cache nodes\nhttp://a:8001\n\n  http://b:8001  \n",
+	}
+
+	dir := t.TempDir()
+	for name, content := range tests {
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+		got, err := (&File{Path: path}).Peers(context.Background())
+		if err != nil || !reflect.DeepEqual(got, want) {
+			t.Fatalf("%s: got %v, err %v", name, got, err)
+		}
+	}
+
+	if _, err := (&File{Path: filepath.Join(dir, "missing")}).Peers(context.Background()); err == nil {
+		t.Fatal("missing file should fail")
+	}
+}
+
+func TestParse(t *testing.T) {
+	d, _ := Parse("file:/etc/peers.json")
+	if f, ok := d.(*File); !ok || f.Path != "/etc/peers.json" {
+		t.Fatalf("unexpected %#v", d)
+	}
+	d, _ = Parse("dns:_skycache._tcp.example.com")
+	if r, ok := d.(*DNS); !ok || r.Name != "_skycache._tcp.example.com" {
+		t.Fatalf("unexpected %#v", d)
+	}
+	d, _ = Parse("http://a:8001, http://b:8001")
+	if !reflect.DeepEqual(d, Static{"http://a:8001", "http://b:8001"}) {
+		t.Fatalf("unexpected %#v", d)
+	}
+}
+
+func TestWatchReload(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "peers.txt")
+	os.WriteFile(path, []byte("http://a:8001\n"), 0644)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	changes := make(chan []string, 10)
+	go Watch(ctx, &File{Path: path}, 10*time.Millisecond, func(peers []string) { changes <- peers })
+
+	expect := func(want ...string) {
+		t.Helper()
+		select {
+		case got := <-changes:
+			if !reflect.DeepEqual(got, want) {
+				t.Fatalf("expected %v, got %v", want, got)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("no change for %v", want)
+		}
+	}
+	expect("http://a:8001")
+
+	// 修改文件后自动生效，结果排序去重
+	os.WriteFile(path, []byte("http://c:8001\nhttp://a:8001\nhttp://c:8001\n"), 0644)
+	expect("http://a:8001", "http://c:8001")
+
+	// 文件为空(例如写了一半)或读取失败时保留上一次的结果
+	for _, broken := range []func(){
+		func() { os.WriteFile(path, nil, 0644) },
+		func() { os.WriteFile(path, []byte("# no peers\n"), 0644) },
+		func() { os.Remove(path) },
+	} {
+		broken()
+		time.Sleep(50 * time.Millisecond)
+		select {
+		case got := <-changes:
+			t.Fatalf("unexpected change %v", got)
+		default:
+		}
+	}
+
+	// 恢复后继续生效
+	os.WriteFile(path, []byte("http://b:8001\n"), 0644)
+	expect("http://b:8001")
+}
+
+// 在本机启动一个只回答 SRV 查询的 DNS 服务
+func startStubDNS(t *testing.T, name string, srvs []dnsmessage.SRVResource) string {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+
+	go func() {
+		buf := make([]byte, 512)
+		for {
+			n, addr, err := conn.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+			var p dnsmessage.Parser
+			header, err := p.Start(buf[:n])
+			if err != nil {
+				continue
+			}
+			q, err := p.Question()
+			if err != nil {
+				continue
+			}
+
+			b := dnsmessage.NewBuilder(nil, dnsmessage.Header{ID: header.ID, Response: true, Authoritative: true})
+			b.EnableCompression()
+			b.StartQuestions()
+			b.Question(q)
+			b.StartAnswers()
+			if q.Type == dnsmessage.TypeSRV && q.Name.String() == name {
+				for _, srv := range srvs {
+					b.SRVResource(dnsmessage.ResourceHeader{Name: q.Name, Class: dnsmessage.ClassINET, TTL: 60}, srv)
+				}
+			}
+			resp, err := b.Finish()
+			if err != nil {
+				continue
+			}
+			conn.WriteTo(resp, addr)
+		}
+	}()
+	return conn.LocalAddr().String()
+}
+
+func TestDNS(t *testing.T) {
+	addr := startStubDNS(t, "_skycache._tcp.example.com.", []dnsmessage.SRVResource{
+		{Target: dnsmessage.MustNewName("node1.example.com."), Port: 8001},
+		{Target: dnsmessage.MustNewName("node2.example.com."), Port: 8002},
+	})
+	resolver := &net.Resolver{
+		PreferGo: true,
+		Dial: func(ctx context.Context, network, _ string) (net.Conn, error) {
+			var d net.Dialer
+			return d.DialContext(ctx, "udp", addr)
+		},
+	}
+
+	d := &DNS{Service: "skycache", Proto: "tcp", Name: "example.com", Resolver: resolver}
+	got, err := d.Peers(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"http://node1.example.com:8001", "http://node2.example.com:8002"}
+	if !reflect.DeepEqual(normalize(got), want) {
+		t.Fatalf("expected %v, got %v", want, got)
+	}
+}
@@ -0,0 +1,41 @@
+package discovery
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strings"
+)
+
+// 查询 SRV 记录获取节点列表，每条记录对应一个节点 <Scheme>://<target>:<port>
+//
+// Service 和 Proto 为空时直接查询 Name，例如 "_skycache._tcp.example.com"
+type DNS struct {
+	Service  string
+	Proto    string
+	Name     string
+	Scheme   string        //默认为 http
+	Resolver *net.Resolver //为 nil 则使用 net.DefaultResolver
+}
+
+func (d *DNS) Peers(ctx context.Context) ([]string, error) {
+	resolver := d.Resolver
+	if resolver == nil {
+		resolver = net.DefaultResolver
+	}
+	scheme := d.Scheme
+	if scheme == "" {
+		scheme = "http"
+	}
+
+	_, records, err := resolver.LookupSRV(ctx, d.Service, d.Proto, d.Name)
+	if err != nil {
+		return nil, fmt.Errorf("discovery: lookup %s: %v", d.Name, err)
+	}
+	peers := make([]string, 0, len(records))
+	for _, r := range records {
+		host := strings.TrimSuffix(r.Target, ".")
+		peers = append(peers, fmt.Sprintf("%s://%s", scheme, net.JoinHostPort(host, fmt.Sprint(r.Port))))
+	}
+	return peers, nil
+}
@@ -0,0 +1,86 @@
+package discovery
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// 从文件读取节点列表，根据扩展名选择格式：
+//
+//	.json         ["http://a:8001", ...] 或 {"peers": [...]}
+//	.yaml, .yml   - http://a:8001 或 peers: [...]
+//	其他          每行一个地址，忽略空行和 # 开头的注释
+//
+// 每次调用 Peers 都会重新读取文件，配合 Watch 即可在文件修改后生效
+type File struct {
+	Path string
+}
+
+// json 和 yaml 中的对象形式
+type peersDoc struct {
+	Peers []string `json:"peers" yaml:"peers"`
+}
+
+func (f *File) Peers(ctx context.Context) ([]string, error) {
+	data, err := os.ReadFile(f.Path)
+	if err != nil {
+		return nil, err
+	}
+
+	var peers []string
+	switch strings.ToLower(filepath.Ext(f.Path)) {
+	case ".json":
+		peers, err = parseJSON(data)
+	case ".yaml", ".yml":
+		peers, err = parseYAML(data)
+	default:
+		peers, err = parseLines(data)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("discovery: parse %s: %v", f.Path, err)
+	}
+	return peers, nil
+}
+
+func parseJSON(data []byte) ([]string, error) {
+	data = bytes.TrimSpace(data)
+	if len(data) > 0 && data[0] == '[' {
+		var peers []string
+		err := json.Unmarshal(data, &peers)
+		return peers, err
+	}
+	var doc peersDoc
+	err := json.Unmarshal(data, &doc)
+	return doc.Peers, err
+}
+
+func parseYAML(data []byte) ([]string, error) {
+	var peers []string
+	if err := yaml.Unmarshal(data, &peers); err == nil {
+		return peers, nil
+	}
+	var doc peersDoc
+	err := yaml.Unmarshal(data, &doc)
+	return doc.Peers, err
+}
+
+func parseLines(data []byte) ([]string, error) {
+	var peers []string
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		peers = append(peers, line)
+	}
+	return peers, scanner.Err()
+}
@@ -0,0 +1,82 @@
+package skycache
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// Getter 找不到 key 时应返回 ErrNotFound(可以用 fmt.Errorf("%w") 包装)，
+// 它会原样经过远程节点传回调用方，可以用 errors.Is 判断
+var ErrNotFound = errors.New("skycache: key not found")
+
+// 访问远程节点失败，Addr 为远程节点的地址
+type PeerError struct {
+	Addr string
+	Err  error
+}
+
+func (e *PeerError) Error() string {
+	return fmt.Sprintf("peer %s: %v", e.Addr, e.Err)
+}
+
+func (e *PeerError) Unwrap() error {
+	return e.Err
+}
+
+// 把错误映射为 HTTP 状态码，Client 根据状态码重建错误
+func httpStatus(err error) int {
+	switch {
+	case errors.Is(err, ErrNotFound):
+		return http.StatusNotFound
+	case errors.Is(err, context.DeadlineExceeded):
+		return http.StatusGatewayTimeout
+	case errors.Is(err, context.Canceled):
+		return http.StatusServiceUnavailable
+	}
+	return http.StatusInternalServerError
+}
+
+// httpStatus 的逆过程，msg 为响应的内容
+func errorFromHTTP(code int, msg string) error {
+	switch code {
+	case http.StatusNotFound:
+		return ErrNotFound
+	case http.StatusGatewayTimeout:
+		return context.DeadlineExceeded
+	}
+	return fmt.Errorf("server returned: %v %s", code, msg)
+}
+
+// 把错误转换为 gRPC 的 status
+func grpcStatus(err error) error {
+	if err == nil {
+		return nil
+	}
+	switch {
+	case errors.Is(err, ErrNotFound):
+		return status.Error(codes.NotFound, err.Error())
+	case errors.Is(err, context.DeadlineExceeded):
+		return status.Error(codes.DeadlineExceeded, err.Error())
+	case errors.Is(err, context.Canceled):
+		return status.Error(codes.Canceled, err.Error())
+	}
+	return status.Error(codes.Unknown, err.Error())
+}
+
+// grpcStatus 的逆过程
+func errorFromGRPC(err error) error {
+	switch status.Code(err) {
+	case codes.NotFound:
+		return ErrNotFound
+	case codes.DeadlineExceeded:
+		return context.DeadlineExceeded
+	case codes.Canceled:
+		return context.Canceled
+	}
+	return err
+}
@@ -0,0 +1,65 @@
+// eviction 定义了 cache 依赖的淘汰策略接口，
+// lru、lfu、fifo、arc、tinylfu 等包分别实现了不同的淘汰策略
+package eviction
+
+import "time"
+
+// 缓存的值，只需要能够计算占用的字节数
+type Value interface {
+	Len() int
+}
+
+// 记录被移出 cache 的原因
+type EvictReason int
+
+const (
+	Expired EvictReason = iota //已过期
+	Evicted                    //容量不足被淘汰
+	Removed                    //被主动删除
+)
+
+func (r EvictReason) String() string {
+	switch r {
+	case Expired:
+		return "expired"
+	case Evicted:
+		return "evicted"
+	case Removed:
+		return "removed"
+	}
+	return "unknown"
+}
+
+// 记录被移出 cache 时的回调
+type OnEvicted func(key string, value Value, reason EvictReason)
+
+// 淘汰策略需要实现的接口，实现不需要保证并发安全
+type Cache interface {
+	// 查询，已过期的记录视为未命中
+	Get(key string) (Value, bool)
+	// 写入，永不过期
+	Set(key string, value Value)
+	// 写入并设置过期时间，ttl <= 0 表示永不过期
+	SetWithTTL(key string, value Value, ttl time.Duration)
+	// 主动删除
+	Remove(key string)
+	// 清除所有已过期的记录，返回清除的数量
+	RemoveExpired() int
+	// 记录数量
+	Len() int
+	// 当前占用的字节数
+	Bytes() int64
+}
+
+// 根据 ttl 计算过期时间，零值表示永不过期
+func Deadline(ttl time.Duration) time.Time {
+	if ttl <= 0 {
+		return time.Time{}
+	}
+	return time.Now().Add(ttl)
+}
+
+// 判断过期时间 expire 在 now 时是否已经过期
+func IsExpired(expire, now time.Time) bool {
+	return !expire.IsZero() && now.After(expire)
+}
@@ -0,0 +1,107 @@
+package fifo
+
+import (
+	"container/list"
+	"skycache/eviction"
+	"time"
+)
+
+var _ eviction.Cache = (*Cache)(nil)
+
+// FIFO Cache，按写入顺序淘汰，查询不影响淘汰顺序
+type Cache struct {
+	maxBytes  int64                    //最大容量，为 0 则无上限
+	nBytes    int64                    //当前容量
+	ll        *list.List               //按写入顺序存储，队首为最新写入
+	cache     map[string]*list.Element //存储key-value对
+	OnEvicted eviction.OnEvicted       //回调函数，可选
+}
+
+type entry struct {
+	key    string
+	value  eviction.Value
+	expire time.Time //过期时间，零值表示永不过期
+}
+
+func New(maxBytes int64, onEvicted eviction.OnEvicted) *Cache {
+	return &Cache{
+		maxBytes:  maxBytes,
+		ll:        list.New(),
+		cache:     make(map[string]*list.Element),
+		OnEvicted: onEvicted,
+	}
+}
+
+// 查询，不改变记录的位置
+func (c *Cache) Get(key string) (eviction.Value, bool) {
+	if e, ok := c.cache[key]; ok {
+		kv := e.Value.(*entry)
+		if eviction.IsExpired(kv.expire, time.Now()) {
+			c.removeElement(e, eviction.Expired)
+			return nil, false
+		}
+		return kv.value, true
+	}
+	return nil, false
+}
+
+func (c *Cache) Set(key string, value eviction.Value) {
+	c.SetWithTTL(key, value, 0)
+}
+
+// 写入，更新已有记录时保持其原本的位置
+func (c *Cache) SetWithTTL(key string, value eviction.Value, ttl time.Duration) {
+	expire := eviction.Deadline(ttl)
+
+	if e, ok := c.cache[key]; ok {
+		kv := e.Value.(*entry)
+		c.nBytes += int64(value.Len()) - int64(kv.value.Len())
+		kv.value = value
+		kv.expire = expire
+	} else {
+		c.cache[key] = c.ll.PushFront(&entry{key: key, value: value, expire: expire})
+		c.nBytes += int64(len(key) + value.Len())
+	}
+
+	for c.maxBytes != 0 && c.nBytes > c.maxBytes {
+		c.removeElement(c.ll.Back(), eviction.Evicted)
+	}
+}
+
+func (c *Cache) Remove(key string) {
+	if e, ok := c.cache[key]; ok {
+		c.removeElement(e, eviction.Removed)
+	}
+}
+
+func (c *Cache) RemoveExpired() int {
+	now := time.Now()
+	n := 0
+	for e := c.ll.Back(); e != nil; {
+		prev := e.Prev()
+		if eviction.IsExpired(e.Value.(*entry).expire, now) {
+			c.removeElement(e, eviction.Expired)
+			n++
+		}
+		e = prev
+	}
+	return n
+}
+
+func (c *Cache) removeElement(e *list.Element, reason eviction.EvictReason) {
+	kv := e.Value.(*entry)
+	c.ll.Remove(e)
+	delete(c.cache, kv.key)
+	c.nBytes -= int64(len(kv.key) + kv.value.Len())
+	if c.OnEvicted != nil {
+		c.OnEvicted(kv.key, kv.value, reason)
+	}
+}
+
+func (c *Cache) Len() int {
+	return c.ll.Len()
+}
+
+func (c *Cache) Bytes() int64 {
+	return c.nBytes
+}
@@ -0,0 +1,64 @@
+package fifo_test
+
+import (
+	"reflect"
+	"skycache/eviction"
+	"skycache/fifo"
+	"testing"
+	"time"
+)
+
+type String string
+
+func (s String) Len() int {
+	return len(s)
+}
+
+func TestGet(t *testing.T) {
+	c := fifo.New(0, nil)
+	c.Set("1", String("val1"))
+	if v, ok := c.Get("1"); !ok || string(v.(String)) != "val1" {
+		t.Fatalf("cache hit key 1 failed, expecetd val1, got %v", v)
+	}
+
+	if v, ok := c.Get("2"); ok {
+		t.Fatalf("cache hit key 2 failed, expected nil, got %v", v)
+	}
+}
+
+func TestRemoveOldest(t *testing.T) {
+	keys := make([]string, 0)
+	callback := func(key string, value eviction.Value, reason eviction.EvictReason) {
+		keys = append(keys, key)
+	}
+
+	c := fifo.New(int64(12), callback)
+	c.Set("k1", String("v1"))
+	c.Set("k2", String("v2"))
+	c.Set("k3", String("v3"))
+
+	// 访问 k1 不会改变淘汰顺序
+	c.Get("k1")
+	c.Set("k4", String("v4"))
+
+	if _, ok := c.Get("k1"); ok || c.Len() != 3 {
+		t.Fatalf("k1 should be evicted first")
+	}
+	if !reflect.DeepEqual([]string{"k1"}, keys) {
+		t.Fatalf("Call OnEvicted failed, got %v", keys)
+	}
+}
+
+func TestSetWithTTL(t *testing.T) {
+	c := fifo.New(0, nil)
+	c.SetWithTTL("k1", String("val1"), 10*time.Millisecond)
+	c.Set("k2", String("val2"))
+
+	time.Sleep(20 * time.Millisecond)
+	if _, ok := c.Get("k1"); ok {
+		t.Fatalf("k1 should be expired")
+	}
+	if n := c.RemoveExpired(); n != 0 || c.Bytes() != int64(len("k2")+len("val2")) {
+		t.Fatalf("only k2 should be left, bytes %d", c.Bytes())
+	}
+}
@@ -0,0 +1,554 @@
+package gossip
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"math/rand"
+	"net"
+	"slices"
+	"sort"
+	"sync"
+	"time"
+)
+
+// 基于 SWIM 协议的集群成员管理，节点之间使用 UDP 通信
+//
+// 每个周期随机选择一个节点发送 ping，超时未收到 ack 时，请其他 k 个节点代为 ping(ping-req)，
+// 仍然失败则标记为 suspect，suspect 超过 SuspicionTimeout 后标记为 dead。
+// 成员的变化附带在每条消息中传播，被怀疑的节点收到关于自己的消息后，增加 incarnation 进行反驳。
+//
+// 与 Server 配合使用：
+//
+//	node, _ := gossip.New(gossip.Config{
+//		Name:     "http://10.0.0.1:8001",
+//		BindAddr: "10.0.0.1:7946",
+//		OnChange: func(members []string) { server.Set(members...) },
+//	})
+//	node.Join("10.0.0.2:7946")
+
+// 成员的状态
+type State int
+
+const (
+	Alive   State = iota //正常
+	Suspect              //探测失败，等待反驳
+	Dead                 //确认失败或主动离开
+)
+
+func (s State) String() string {
+	switch s {
+	case Alive:
+		return "alive"
+	case Suspect:
+		return "suspect"
+	case Dead:
+		return "dead"
+	}
+	return "unknown"
+}
+
+type Member struct {
+	Name        string `json:"name"` //对外的地址，例如 cache 节点的 http 地址，作为成员的标识
+	Addr        string `json:"addr"` //gossip 使用的 UDP 地址
+	State       State  `json:"state"`
+	Incarnation uint64 `json:"inc"` //只有成员自己可以增加，用于反驳 suspect
+}
+
+type Config struct {
+	Name             string        //本节点对外的地址
+	BindAddr         string        //监听的 UDP 地址，例如 "127.0.0.1:0"
+	ProbeInterval    time.Duration //探测周期，默认 1s
+	ProbeTimeout     time.Duration //等待 ack 的时间，默认 300ms，应小于 ProbeInterval
+	SuspicionTimeout time.Duration //suspect 转为 dead 的时间，默认 5s
+	IndirectChecks   int           //ping-req 的节点数，默认 3
+	// 存活成员(包括 suspect)变化时调用，参数为排好序的 Name，包含自己
+	// 在单独的 goroutine 中按顺序调用，处理不过来时只保留最新的成员
+	OnChange func(members []string)
+}
+
+const (
+	defaultProbeInterval    = time.Second
+	defaultProbeTimeout     = 300 * time.Millisecond
+	defaultSuspicionTimeout = 5 * time.Second
+	defaultIndirectChecks   = 3
+
+	// dead 的成员保留一段时间，以便继续传播，之后删除
+	deadReclaimFactor = 10
+)
+
+// 消息类型
+const (
+	msgPing    = "ping"
+	msgAck     = "ack"
+	msgPingReq = "ping-req"
+)
+
+type message struct {
+	Type    string   `json:"type"`
+	Seq     uint64   `json:"seq"`
+	Target  string   `json:"target,omitempty"`  //ping-req 要探测的地址
+	Members []Member `json:"members,omitempty"` //附带的成员信息
+}
+
+type member struct {
+	Member
+	since time.Time //进入当前状态的时间
+}
+
+type Node struct {
+	cfg  Config
+	conn *net.UDPConn
+
+	mu      sync.Mutex
+	members map[string]*member //Name -> member，包括自己
+	seq     uint64
+	acks    map[uint64]chan struct{}
+	order   []string //本轮探测的顺序
+	left    bool     //已主动离开，不再反驳
+
+	notifyMu sync.Mutex    //保证成员变化按顺序放入 changes
+	last     []string      //上一次通知的成员
+	changes  chan []string //等待 OnChange 处理的最新成员，容量为 1
+
+	done      chan struct{}
+	wg        sync.WaitGroup
+	closeOnce sync.Once
+}
+
+// 创建节点并开始探测，此时集群中只有自己，使用 Join 加入已有的集群
+func New(cfg Config) (*Node, error) {
+	if cfg.Name == "" {
+		return nil, errors.New("gossip: name is must")
+	}
+	if cfg.ProbeInterval <= 0 {
+		cfg.ProbeInterval = defaultProbeInterval
+	}
+	if cfg.ProbeTimeout <= 0 {
+		cfg.ProbeTimeout = min(defaultProbeTimeout, cfg.ProbeInterval/2)
+	}
+	if cfg.SuspicionTimeout <= 0 {
+		cfg.SuspicionTimeout = defaultSuspicionTimeout
+	}
+	if cfg.IndirectChecks <= 0 {
+		cfg.IndirectChecks = defaultIndirectChecks
+	}
+
+	laddr, err := net.ResolveUDPAddr("udp", cfg.BindAddr)
+	if err != nil {
+		return nil, err
+	}
+	conn, err := net.ListenUDP("udp", laddr)
+	if err != nil {
+		return nil, err
+	}
+
+	n := &Node{
+		cfg:     cfg,
+		conn:    conn,
+		members: make(map[string]*member),
+		acks:    make(map[uint64]chan struct{}),
+		changes: make(chan []string, 1),
+		done:    make(chan struct{}),
+	}
+	n.members[cfg.Name] = &member{
+		Member: Member{Name: cfg.Name, Addr: conn.LocalAddr().String(), State: Alive},
+		since:  time.Now(),
+	}
+	n.notify()
+
+	n.wg.Add(3)
+	go n.readLoop()
+	go n.probeLoop()
+	go n.changeLoop()
+	return n, nil
+}
+
+func (n *Node) Log(format string, v ...interface{}) {
+	log.Printf("[Gossip %s] %s", n.cfg.Name, fmt.Sprintf(format, v...))
+}
+
+// 实际监听的 UDP 地址
+func (n *Node) Addr() string {
+	return n.conn.LocalAddr().String()
+}
+
+// 通过种子节点加入集群，至少一个种子节点响应时成功
+func (n *Node) Join(seeds ...string) error {
+	joined := 0
+	for _, seed := range seeds {
+		if seed == n.Addr() {
+			continue
+		}
+		if n.ping(seed, n.cfg.ProbeInterval) {
+			joined++
+		} else {
+			n.Log("seed %s did not respond", seed)
+		}
+	}
+	if joined == 0 && len(seeds) > 0 {
+		return errors.New("gossip: no seed responded")
+	}
+	return nil
+}
+
+// 返回所有已知的成员，按 Name 排序
+func (n *Node) Members() []Member {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	members := make([]Member, 0, len(n.members))
+	for _, m := range n.members {
+		members = append(members, m.Member)
+	}
+	sort.Slice(members, func(i, j int) bool { return members[i].Name < members[j].Name })
+	return members
+}
+
+// 主动离开集群：以更高的 incarnation 把自己标记为 dead 并通知其他成员，然后关闭
+func (n *Node) Leave() error {
+	n.mu.Lock()
+	self := n.members[n.cfg.Name]
+	self.State = Dead
+	self.Incarnation++
+	n.left = true
+	var addrs []string
+	for _, m := range n.members {
+		if m.Name != n.cfg.Name && m.State != Dead {
+			addrs = append(addrs, m.Addr)
+		}
+	}
+	n.mu.Unlock()
+
+	for _, addr := range addrs {
+		n.send(addr, message{Type: msgPing})
+	}
+	return n.Close()
+}
+
+// 停止探测并关闭连接，不通知其他成员
+func (n *Node) Close() error {
+	var err error
+	n.closeOnce.Do(func() {
+		close(n.done)
+		err = n.conn.Close()
+		n.wg.Wait()
+	})
+	return err
+}
+
+func (n *Node) readLoop() {
+	defer n.wg.Done()
+	buf := make([]byte, 64<<10)
+	for {
+		size, from, err := n.conn.ReadFromUDP(buf)
+		if err != nil {
+			select {
+			case <-n.done:
+				return
+			default:
+				n.Log("read: %v", err)
+				continue
+			}
+		}
+		var msg message
+		if err := json.Unmarshal(buf[:size], &msg); err != nil {
+			n.Log("bad message from %v: %v", from, err)
+			continue
+		}
+		n.handle(msg, from.String())
+	}
+}
+
+func (n *Node) handle(msg message, from string) {
+	n.merge(msg.Members)
+
+	switch msg.Type {
+	case msgPing:
+		n.send(from, message{Type: msgAck, Seq: msg.Seq})
+	case msgAck:
+		n.mu.Lock()
+		ch, ok := n.acks[msg.Seq]
+		n.mu.Unlock()
+		if ok {
+			select {
+			case ch <- struct{}{}:
+			default:
+			}
+		}
+	case msgPingReq:
+		// 代为探测，成功后用原来的 seq 回复请求方
+		go func() {
+			if n.ping(msg.Target, n.cfg.ProbeTimeout) {
+				n.send(from, message{Type: msgAck, Seq: msg.Seq})
+			}
+		}()
+	}
+}
+
+// 发送消息，附带当前所有成员的信息
+func (n *Node) send(addr string, msg message) {
+	msg.Members = n.Members()
+	b, err := json.Marshal(msg)
+	if err != nil {
+		n.Log("encode: %v", err)
+		return
+	}
+	raddr, err := net.ResolveUDPAddr("udp", addr)
+	if err != nil {
+		n.Log("resolve %s: %v", addr, err)
+		return
+	}
+	if _, err := n.conn.WriteToUDP(b, raddr); err != nil {
+		select {
+		case <-n.done:
+		default:
+			n.Log("send to %s: %v", addr, err)
+		}
+	}
+}
+
+// 注册一个等待 ack 的 seq
+func (n *Node) expectAck() (uint64, chan struct{}) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.seq++
+	ch := make(chan struct{}, 1)
+	n.acks[n.seq] = ch
+	return n.seq, ch
+}
+
+func (n *Node) forgetAck(seq uint64) {
+	n.mu.Lock()
+	delete(n.acks, seq)
+	n.mu.Unlock()
+}
+
+func (n *Node) waitAck(ch chan struct{}, timeout time.Duration) bool {
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	select {
+	case <-ch:
+		return true
+	case <-timer.C:
+	case <-n.done:
+	}
+	return false
+}
+
+// 直接探测 addr，在 timeout 内收到 ack 时返回 true
+func (n *Node) ping(addr string, timeout time.Duration) bool {
+	seq, ch := n.expectAck()
+	defer n.forgetAck(seq)
+	n.send(addr, message{Type: msgPing, Seq: seq})
+	return n.waitAck(ch, timeout)
+}
+
+func (n *Node) probeLoop() {
+	defer n.wg.Done()
+	ticker := time.NewTicker(n.cfg.ProbeInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-n.done:
+			return
+		case <-ticker.C:
+			n.probe()
+			n.reap()
+		}
+	}
+}
+
+// 探测一个成员，直接探测和间接探测都失败时标记为 suspect
+func (n *Node) probe() {
+	target, ok := n.nextTarget()
+	if !ok {
+		return
+	}
+	if n.ping(target.Addr, n.cfg.ProbeTimeout) {
+		return
+	}
+
+	// 间接探测，任意一个节点回复即可
+	seq, ch := n.expectAck()
+	defer n.forgetAck(seq)
+	helpers := n.randomMembers(n.cfg.IndirectChecks, target.Name)
+	for _, m := range helpers {
+		n.send(m.Addr, message{Type: msgPingReq, Seq: seq, Target: target.Addr})
+	}
+	if len(helpers) > 0 && n.waitAck(ch, n.cfg.ProbeInterval-n.cfg.ProbeTimeout) {
+		return
+	}
+
+	n.mu.Lock()
+	m, ok := n.members[target.Name]
+	changed := ok && m.State == Alive && m.Incarnation == target.Incarnation
+	if changed {
+		m.State = Suspect
+		m.since = time.Now()
+		n.Log("suspect %s", m.Name)
+	}
+	n.mu.Unlock()
+	if changed {
+		n.notify()
+	}
+}
+
+// 按随机顺序轮流选择探测对象，每一轮每个成员都会被探测到
+func (n *Node) nextTarget() (Member, bool) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	for {
+		if len(n.order) == 0 {
+			for name, m := range n.members {
+				if name != n.cfg.Name && m.State != Dead {
+					n.order = append(n.order, name)
+				}
+			}
+			if len(n.order) == 0 {
+				return Member{}, false
+			}
+			rand.Shuffle(len(n.order), func(i, j int) { n.order[i], n.order[j] = n.order[j], n.order[i] })
+		}
+		name := n.order[0]
+		n.order = n.order[1:]
+		if m, ok := n.members[name]; ok && m.State != Dead {
+			return m.Member, true
+		}
+	}
+}
+
+// 随机选择最多 k 个存活的成员，不包括自己和 exclude
+func (n *Node) randomMembers(k int, exclude string) []Member {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	var candidates []Member
+	for name, m := range n.members {
+		if name != n.cfg.Name && name != exclude && m.State == Alive {
+			candidates = append(candidates, m.Member)
+		}
+	}
+	rand.Shuffle(len(candidates), func(i, j int) { candidates[i], candidates[j] = candidates[j], candidates[i] })
+	return candidates[:min(k, len(candidates))]
+}
+
+// suspect 超时的成员标记为 dead，dead 太久的成员删除
+func (n *Node) reap() {
+	now := time.Now()
+	changed := false
+
+	n.mu.Lock()
+	for name, m := range n.members {
+		switch {
+		case m.State == Suspect && now.Sub(m.since) > n.cfg.SuspicionTimeout:
+			m.State = Dead
+			m.since = now
+			changed = true
+			n.Log("%s is dead", name)
+		case m.State == Dead && name != n.cfg.Name && now.Sub(m.since) > deadReclaimFactor*n.cfg.SuspicionTimeout:
+			delete(n.members, name)
+		}
+	}
+	n.mu.Unlock()
+
+	if changed {
+		n.notify()
+	}
+}
+
+// 合并其他成员传来的信息：incarnation 更大的优先，相同时 dead > suspect > alive
+func (n *Node) merge(updates []Member) {
+	now := time.Now()
+	changed := false
+
+	n.mu.Lock()
+	for _, u := range updates {
+		if u.Name == n.cfg.Name {
+			// 关于自己的怀疑，增加 incarnation 进行反驳
+			self := n.members[n.cfg.Name]
+			if n.left || u.Incarnation < self.Incarnation {
+				continue
+			}
+			if u.State != Alive {
+				self.Incarnation = u.Incarnation + 1
+				n.Log("refute %s with incarnation %d", u.State, self.Incarnation)
+			} else {
+				// 重启后其他成员可能记得更大的 incarnation
+				self.Incarnation = u.Incarnation
+			}
+			continue
+		}
+
+		m, ok := n.members[u.Name]
+		if !ok {
+			// 已删除的 dead 成员不需要重新加入
+			if u.State == Dead {
+				continue
+			}
+			n.members[u.Name] = &member{Member: u, since: now}
+			changed = true
+			continue
+		}
+		if u.Incarnation > m.Incarnation || (u.Incarnation == m.Incarnation && u.State > m.State) {
+			if u.State != m.State {
+				m.since = now
+				changed = true
+			}
+			m.Member = u
+		}
+	}
+	n.mu.Unlock()
+
+	if changed {
+		n.notify()
+	}
+}
+
+// 存活成员变化时调用 OnChange
+func (n *Node) notify() {
+	n.notifyMu.Lock()
+	defer n.notifyMu.Unlock()
+
+	n.mu.Lock()
+	var names []string
+	for name, m := range n.members {
+		if m.State != Dead {
+			names = append(names, name)
+		}
+	}
+	n.mu.Unlock()
+	sort.Strings(names)
+
+	if slices.Equal(names, n.last) {
+		return
+	}
+	n.last = names
+	n.Log("members %v", names)
+
+	// 不在协议的 goroutine 中调用 OnChange，它阻塞时 ack 得不到处理，其他成员会被误判
+	// 只有这里放入 changes，丢弃未处理的旧成员后一定有空位
+	select {
+	case n.changes <- names:
+	default:
+		select {
+		case <-n.changes:
+		default:
+		}
+		n.changes <- names
+	}
+}
+
+// 依次把成员变化交给 OnChange
+func (n *Node) changeLoop() {
+	defer n.wg.Done()
+	for {
+		select {
+		case <-n.done:
+			return
+		case names := <-n.changes:
+			if n.cfg.OnChange != nil {
+				n.cfg.OnChange(names)
+			}
+		}
+	}
+}
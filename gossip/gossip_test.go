@@ -0,0 +1,183 @@
+package gossip
+
+import (
+	"fmt"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+)
+
+// 记录 OnChange 的最新结果
+type recorder struct {
+	mu      sync.Mutex
+	members []string
+}
+
+func (r *recorder) set(members []string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.members = members
+}
+
+func (r *recorder) get() []string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.members
+}
+
+// 在本机启动 n 个节点，并通过第一个节点加入集群
+func startCluster(t *testing.T, n int) ([]*Node, []*recorder) {
+	nodes := make([]*Node, n)
+	recorders := make([]*recorder, n)
+	for i := range nodes {
+		recorders[i] = &recorder{}
+		node, err := New(Config{
+			Name:             fmt.Sprintf("http://node%d", i),
+			BindAddr:         "127.0.0.1:0",
+			ProbeInterval:    20 * time.Millisecond,
+			ProbeTimeout:     10 * time.Millisecond,
+			SuspicionTimeout: 100 * time.Millisecond,
+			OnChange:         recorders[i].set,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { node.Close() })
+		nodes[i] = node
+		if i > 0 {
+			if err := node.Join(nodes[0].Addr()); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	return nodes, recorders
+}
+
+// 在超时前等待条件成立
+func eventually(t *testing.T, timeout time.Duration, cond func() bool, msg string) {
+	t.Helper()
+	deadline := time.Now().Add(timeout)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatal(msg)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestJoin(t *testing.T) {
+	_, recorders := startCluster(t, 4)
+	want := []string{"http://node0", "http://node1", "http://node2", "http://node3"}
+	for i, r := range recorders {
+		eventually(t, 2*time.Second, func() bool {
+			return slices.Equal(r.get(), want)
+		}, fmt.Sprintf("node%d did not converge, got %v", i, r.get()))
+	}
+}
+
+func TestJoinNoSeed(t *testing.T) {
+	node, err := New(Config{Name: "http://lonely", BindAddr: "127.0.0.1:0", ProbeInterval: 20 * time.Millisecond})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer node.Close()
+	if err := node.Join("127.0.0.1:1"); err == nil {
+		t.Fatal("join should fail without any live seed")
+	}
+}
+
+func TestFailureDetection(t *testing.T) {
+	nodes, recorders := startCluster(t, 3)
+	eventually(t, 2*time.Second, func() bool {
+		return len(recorders[0].get()) == 3 && len(recorders[1].get()) == 3
+	}, "cluster did not converge")
+
+	// node2 直接退出，其他节点先怀疑再确认
+	nodes[2].Close()
+	want := []string{"http://node0", "http://node1"}
+	for i := 0; i < 2; i++ {
+		r := recorders[i]
+		eventually(t, 2*time.Second, func() bool {
+			return slices.Equal(r.get(), want)
+		}, fmt.Sprintf("node%d did not detect the failure, got %v", i, r.get()))
+	}
+
+	for _, m := range nodes[0].Members() {
+		if m.Name == "http://node2" && m.State != Dead {
+			t.Fatalf("node2 should be dead, got %v", m.State)
+		}
+	}
+}
+
+func TestLeave(t *testing.T) {
+	nodes, recorders := startCluster(t, 3)
+	eventually(t, 2*time.Second, func() bool {
+		return len(recorders[0].get()) == 3
+	}, "cluster did not converge")
+
+	nodes[1].Leave()
+	// 主动离开不需要等待 suspicion 超时
+	eventually(t, 50*time.Millisecond, func() bool {
+		return slices.Equal(recorders[0].get(), []string{"http://node0", "http://node2"})
+	}, fmt.Sprintf("leave was not propagated, got %v", recorders[0].get()))
+}
+
+func TestRefute(t *testing.T) {
+	nodes, recorders := startCluster(t, 2)
+	eventually(t, 2*time.Second, func() bool {
+		return len(recorders[1].get()) == 2
+	}, "cluster did not converge")
+
+	// 模拟 node1 误以为 node0 出现故障，node0 会反驳
+	nodes[1].merge([]Member{{Name: "http://node0", Addr: nodes[0].Addr(), State: Suspect}})
+	eventually(t, time.Second, func() bool {
+		for _, m := range nodes[1].Members() {
+			if m.Name == "http://node0" {
+				return m.State == Alive && m.Incarnation > 0
+			}
+		}
+		return false
+	}, "node0 did not refute the suspicion")
+
+	time.Sleep(200 * time.Millisecond)
+	if got := recorders[1].get(); len(got) != 2 {
+		t.Fatalf("node0 should stay in the cluster, got %v", got)
+	}
+}
+
+func TestSlowOnChange(t *testing.T) {
+	nodes, recorders := startCluster(t, 3)
+	eventually(t, 2*time.Second, func() bool {
+		return len(recorders[0].get()) == 3
+	}, "cluster did not converge")
+
+	// 一个很慢的 OnChange 不影响探测，存活的成员不会被怀疑
+	slow, err := New(Config{
+		Name:             "http://slow",
+		BindAddr:         "127.0.0.1:0",
+		ProbeInterval:    20 * time.Millisecond,
+		ProbeTimeout:     10 * time.Millisecond,
+		SuspicionTimeout: 100 * time.Millisecond,
+		OnChange:         func([]string) { time.Sleep(300 * time.Millisecond) },
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer slow.Close()
+	if err := slow.Join(nodes[0].Addr()); err != nil {
+		t.Fatal(err)
+	}
+
+	time.Sleep(300 * time.Millisecond)
+	for _, m := range slow.Members() {
+		if m.State != Alive {
+			t.Fatalf("%s should stay alive, got %v", m.Name, m.State)
+		}
+	}
+	for _, m := range nodes[0].Members() {
+		if m.State != Alive {
+			t.Fatalf("%s should stay alive on node0, got %v", m.Name, m.State)
+		}
+	}
+}
@@ -0,0 +1,252 @@
+package skycache
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"skycache/consistenthash"
+	pb "skycache/skycachepb"
+
+	"google.golang.org/grpc"
+)
+
+// 使用 gRPC 为节点之间提供通信能力，可以代替 Server 注册到 Group 中
+
+// 确保 实现了对应的接口
+var (
+	_ ReplicaPicker       = (*GRPCPool)(nil)
+	_ LeasePicker         = (*GRPCPool)(nil)
+	_ pb.GroupCacheServer = (*grpcService)(nil)
+)
+
+type GRPCPool struct {
+	addr        string     // ip:port 的形式，也是 grpc 的 dial target
+	mu          sync.Mutex // guards peers and grpcGetters
+	peers       *consistenthash.HashMap
+	grpcGetters map[string]*GRPCClient
+	dialOpts    []grpc.DialOption
+	groups      func(name string) *Group
+}
+
+// dialOpts 用于连接其他节点，例如 grpc.WithTransportCredentials
+func NewGRPCPool(self string, dialOpts ...grpc.DialOption) *GRPCPool {
+	return &GRPCPool{
+		addr:     self,
+		dialOpts: dialOpts,
+	}
+}
+
+func (p *GRPCPool) Log(format string, v ...interface{}) {
+	log.Printf("[GRPCPool %s] %s", p.addr, fmt.Sprintf(format, v...))
+}
+
+// 把 GroupCache 服务注册到 s 中，由 s 响应其他节点的请求
+func (p *GRPCPool) Register(s grpc.ServiceRegistrar) {
+	pb.RegisterGroupCacheServer(s, &grpcService{pool: p})
+}
+
+// Set updates the pool's list of peers.
+func (p *GRPCPool) Set(peers ...string) error {
+	getters := make(map[string]*GRPCClient, len(peers))
+	for _, peer := range peers {
+		c, err := NewGRPCClient(peer, p.dialOpts...)
+		if err != nil {
+			for _, c := range getters {
+				c.Close()
+			}
+			return err
+		}
+		getters[peer] = c
+	}
+
+	p.mu.Lock()
+	old := p.grpcGetters
+	p.peers = consistenthash.New(defaultReplicas, nil)
+	p.peers.Add(peers...)
+	p.grpcGetters = getters
+	p.mu.Unlock()
+
+	for _, c := range old {
+		c.Close()
+	}
+	return nil
+}
+
+// Close closes the connections to all peers
+func (p *GRPCPool) Close() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for _, c := range p.grpcGetters {
+		c.Close()
+	}
+	p.grpcGetters = nil
+	p.peers = nil
+}
+
+// PickPeer picks a peer according to key
+func (p *GRPCPool) PickPeer(key string) (PeerGetter, bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.peers == nil {
+		return nil, false
+	}
+	if peer, ok := p.peers.Get(key); ok && peer != p.addr {
+		p.Log("Pick peer %s", peer)
+		return p.grpcGetters[peer], true
+	}
+	return nil, false
+}
+
+// 返回 key 的前 n 个所属节点中除自己以外的节点
+func (p *GRPCPool) PickReplicas(key string, n int) []PeerGetter {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.peers == nil {
+		return nil
+	}
+
+	var replicas []PeerGetter
+	for _, peer := range p.peers.GetN(key, n) {
+		if peer != p.addr {
+			replicas = append(replicas, p.grpcGetters[peer])
+		}
+	}
+	return replicas
+}
+
+// 租约节点是哈希环上所属节点之后的第一个节点
+func (p *GRPCPool) PickLeaser(key string) (PeerLeaser, bool, bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.peers == nil {
+		return nil, false, false
+	}
+
+	nodes := p.peers.GetN(key, 2)
+	if len(nodes) < 2 || nodes[0] == p.addr {
+		return nil, false, false
+	}
+	if nodes[1] == p.addr {
+		return nil, true, true
+	}
+	return p.grpcGetters[nodes[1]], false, true
+}
+
+// AllPeers returns all peers except self
+func (p *GRPCPool) AllPeers() []PeerGetter {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	peers := make([]PeerGetter, 0, len(p.grpcGetters))
+	for peer, getter := range p.grpcGetters {
+		if peer != p.addr {
+			peers = append(peers, getter)
+		}
+	}
+	return peers
+}
+
+// 查找 group，测试中可以替换，模拟多个进程
+func (p *GRPCPool) getGroup(name string) *Group {
+	if p.groups != nil {
+		return p.groups(name)
+	}
+	return GetGroup(name)
+}
+
+// 实现 GroupCache 服务，和 Server.ServeHTTP 的逻辑一致
+type grpcService struct {
+	pb.UnimplementedGroupCacheServer
+	pool *GRPCPool
+}
+
+func (s *grpcService) group(name string) (*Group, error) {
+	group := s.pool.getGroup(name)
+	if group == nil {
+		return nil, fmt.Errorf("no such group: %s", name)
+	}
+	group.stats.serverRequests.Add(1)
+	return group, nil
+}
+
+func (s *grpcService) Get(ctx context.Context, in *pb.Request) (*pb.Response, error) {
+	s.pool.Log("Get %s/%s", in.Group, in.Key)
+	group, err := s.group(in.Group)
+	if err != nil {
+		return nil, err
+	}
+
+	view, err := group.Get(withPeerRequest(ctx), in.Key)
+	if errors.Is(err, ErrNotFound) {
+		return &pb.Response{NotFound: true}, nil
+	}
+	if err != nil {
+		return nil, grpcStatus(err)
+	}
+	return &pb.Response{Value: view.ByteSlice()}, nil
+}
+
+func (s *grpcService) GetMulti(ctx context.Context, in *pb.BatchRequest) (*pb.BatchResponse, error) {
+	s.pool.Log("GetMulti %s %d keys", in.Group, len(in.Keys))
+	group, err := s.group(in.Group)
+	if err != nil {
+		return nil, err
+	}
+
+	views, err := group.GetMulti(withPeerRequest(ctx), in.Keys)
+	return batchResponse(views, err), nil
+}
+
+func (s *grpcService) Set(ctx context.Context, in *pb.SetRequest) (*pb.Response, error) {
+	s.pool.Log("Set %s/%s", in.Group, in.Key)
+	group, err := s.group(in.Group)
+	if err != nil {
+		return nil, err
+	}
+
+	if in.Replica {
+		group.setAsReplica(in.Key, ByteView{b: in.Value}, time.Duration(in.Ttl))
+		group.leases.done(in.Key, in.Lease)
+		return &pb.Response{}, nil
+	}
+	if err = group.setAsOwner(ctx, in.Key, ByteView{b: in.Value}, time.Duration(in.Ttl)); err != nil {
+		return nil, grpcStatus(err)
+	}
+	return &pb.Response{}, nil
+}
+
+func (s *grpcService) Remove(ctx context.Context, in *pb.Request) (*pb.Response, error) {
+	s.pool.Log("Remove %s/%s", in.Group, in.Key)
+	group, err := s.group(in.Group)
+	if err != nil {
+		return nil, err
+	}
+
+	if err = group.removeAsOwner(ctx, in.Key); err != nil {
+		return nil, grpcStatus(err)
+	}
+	return &pb.Response{}, nil
+}
+
+func (s *grpcService) Lease(ctx context.Context, in *pb.LeaseRequest) (*pb.LeaseResponse, error) {
+	s.pool.Log("Lease %s/%s", in.Group, in.Key)
+	group, err := s.group(in.Group)
+	if err != nil {
+		return nil, err
+	}
+
+	return group.grantLease(in), nil
+}
+
+func (s *grpcService) Invalidate(ctx context.Context, in *pb.Request) (*pb.Response, error) {
+	group, err := s.group(in.Group)
+	if err != nil {
+		return nil, err
+	}
+
+	group.invalidate(in.Key)
+	return &pb.Response{}, nil
+}
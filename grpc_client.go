@@ -0,0 +1,97 @@
+package skycache
+
+import (
+	"context"
+	"errors"
+	pb "skycache/skycachepb"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+)
+
+// 使用 gRPC 访问远程节点，ctx 的截止时间由 gRPC 传递给远程节点
+
+var _ PeerLeaser = (*GRPCClient)(nil)
+
+type GRPCClient struct {
+	target string
+	conn   *grpc.ClientConn
+	client pb.GroupCacheClient
+}
+
+// 连接是延迟建立的，未指定 credentials 时使用明文传输
+func NewGRPCClient(target string, opts ...grpc.DialOption) (*GRPCClient, error) {
+	opts = append([]grpc.DialOption{grpc.WithTransportCredentials(insecure.NewCredentials())}, opts...)
+	conn, err := grpc.NewClient(target, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return &GRPCClient{target: target, conn: conn, client: pb.NewGroupCacheClient(conn)}, nil
+}
+
+// 把 gRPC 的错误重建为 *PeerError
+func (c *GRPCClient) wrap(err error) error {
+	if err == nil {
+		return nil
+	}
+	return &PeerError{Addr: c.target, Err: errorFromGRPC(err)}
+}
+
+func (c *GRPCClient) Get(ctx context.Context, in *pb.Request, out *pb.Response) error {
+	resp, err := c.client.Get(ctx, in)
+	if err != nil {
+		return c.wrap(err)
+	}
+	out.Value = resp.Value
+	out.NotFound = resp.NotFound
+	if resp.NotFound {
+		return c.wrap(ErrNotFound)
+	}
+	return nil
+}
+
+func (c *GRPCClient) GetMulti(ctx context.Context, in *pb.BatchRequest, out *pb.BatchResponse) error {
+	resp, err := c.client.GetMulti(ctx, in)
+	if err != nil {
+		return c.wrap(err)
+	}
+	out.Values = resp.Values
+	out.Error = resp.Error
+	if resp.Error != "" {
+		return &PeerError{Addr: c.target, Err: errors.New(resp.Error)}
+	}
+	return nil
+}
+
+func (c *GRPCClient) Set(ctx context.Context, in *pb.SetRequest) error {
+	_, err := c.client.Set(ctx, in)
+	return c.wrap(err)
+}
+
+func (c *GRPCClient) Remove(ctx context.Context, in *pb.Request) error {
+	_, err := c.client.Remove(ctx, in)
+	return c.wrap(err)
+}
+
+func (c *GRPCClient) Invalidate(ctx context.Context, in *pb.Request) error {
+	_, err := c.client.Invalidate(ctx, in)
+	return c.wrap(err)
+}
+
+func (c *GRPCClient) Lease(ctx context.Context, in *pb.LeaseRequest, out *pb.LeaseResponse) error {
+	resp, err := c.client.Lease(ctx, in)
+	if err != nil {
+		return c.wrap(err)
+	}
+	out.Granted = resp.Granted
+	out.Found = resp.Found
+	out.Value = resp.Value
+	out.NotFound = resp.NotFound
+	out.Token = resp.Token
+	return nil
+}
+
+// 关闭连接
+func (c *GRPCClient) Close() error {
+	return c.conn.Close()
+}
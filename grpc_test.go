@@ -0,0 +1,176 @@
+package skycache
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"testing"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/test/bufconn"
+)
+
+// 使用 bufconn 在同一进程中启动 n 个 gRPC 节点，不需要真实的网络
+func startGRPCCluster(t *testing.T, name string, n int, getter Getter, opts ...GroupOption) ([]*GRPCPool, []*Group) {
+	listeners := make(map[string]*bufconn.Listener, n)
+	dialer := grpc.WithContextDialer(func(ctx context.Context, addr string) (net.Conn, error) {
+		lis, ok := listeners[addr]
+		if !ok {
+			return nil, fmt.Errorf("no such node: %s", addr)
+		}
+		return lis.DialContext(ctx)
+	})
+
+	pools := make([]*GRPCPool, n)
+	nodes := make([]*Group, n)
+	addrs := make([]string, n)
+	for i := 0; i < n; i++ {
+		// passthrough 使 target 原样交给 dialer，不经过 dns 解析
+		addrs[i] = fmt.Sprintf("passthrough:///node%d", i)
+		lis := bufconn.Listen(1 << 20)
+		listeners[fmt.Sprintf("node%d", i)] = lis
+
+		g := newGroup(name, 2<<10, getter, opts...)
+		p := NewGRPCPool(addrs[i], dialer)
+		p.groups = func(string) *Group { return g }
+
+		s := grpc.NewServer()
+		p.Register(s)
+		go s.Serve(lis)
+		t.Cleanup(s.Stop)
+
+		pools[i], nodes[i] = p, g
+	}
+
+	for i := range pools {
+		if err := pools[i].Set(addrs...); err != nil {
+			t.Fatalf("set peers failed: %v", err)
+		}
+		nodes[i].RegisterPeers(pools[i])
+		t.Cleanup(pools[i].Close)
+	}
+	return pools, nodes
+}
+
+func TestGRPCGet(t *testing.T) {
+	loadCounts := make(map[string]int, len(db))
+	_, nodes := startGRPCCluster(t, "grpc_scores", 3, GetterFunc(
+		func(key string) ([]byte, error) {
+			loadCounts[key]++
+			if v, ok := db[key]; ok {
+				return []byte(v), nil
+			}
+			return nil, fmt.Errorf("%s not exist", key)
+		}))
+
+	// 每个 key 只会在所属节点上加载一次
+	for _, g := range nodes {
+		for k, v := range db {
+			if view, err := g.Get(context.Background(), k); err != nil || view.String() != v {
+				t.Fatalf("failed to get %s, got %s, err %v", k, view, err)
+			}
+		}
+	}
+	for k, n := range loadCounts {
+		if n != 1 {
+			t.Fatalf("%s should be loaded once, but loaded %d times", k, n)
+		}
+	}
+}
+
+func TestGRPCNotFound(t *testing.T) {
+	pools, nodes := startGRPCCluster(t, "grpc_notfound_scores", 2, GetterFunc(
+		func(key string) ([]byte, error) {
+			return nil, ErrNotFound
+		}))
+
+	key := ""
+	for i := 0; key == ""; i++ {
+		if _, ok := pools[0].PickPeer(fmt.Sprint("missing", i)); ok {
+			key = fmt.Sprint("missing", i)
+		}
+	}
+
+	_, err := nodes[0].Get(context.Background(), key)
+	var perr *PeerError
+	if !errors.Is(err, ErrNotFound) || !errors.As(err, &perr) {
+		t.Fatalf("expected ErrNotFound from peer, got %v", err)
+	}
+}
+
+func TestGRPCSetAndRemove(t *testing.T) {
+	_, nodes := startGRPCCluster(t, "grpc_set_scores", 3, GetterFunc(
+		func(key string) ([]byte, error) {
+			return []byte(db[key]), nil
+		}))
+
+	for _, g := range nodes {
+		g.mainCache.set("Tom", ByteView{b: []byte("stale")}, 0)
+	}
+
+	if err := nodes[0].Set(context.Background(), "Tom", ByteView{b: []byte("100")}); err != nil {
+		t.Fatalf("set Tom failed: %v", err)
+	}
+	for i, g := range nodes {
+		if v, err := g.Get(context.Background(), "Tom"); err != nil || v.String() != "100" {
+			t.Fatalf("node %d got %s for Tom after set, err %v", i, v, err)
+		}
+	}
+
+	if err := nodes[2].Remove(context.Background(), "Tom"); err != nil {
+		t.Fatalf("remove Tom failed: %v", err)
+	}
+	for i, g := range nodes {
+		if v, err := g.Get(context.Background(), "Tom"); err != nil || v.String() != db["Tom"] {
+			t.Fatalf("node %d got %s for Tom after remove, err %v", i, v, err)
+		}
+	}
+}
+
+func TestGRPCGetMulti(t *testing.T) {
+	_, nodes := startGRPCCluster(t, "grpc_multi_scores", 3, GetterFunc(
+		func(key string) ([]byte, error) {
+			return []byte(db[key]), nil
+		}))
+
+	for i, g := range nodes {
+		views, err := g.GetMulti(context.Background(), []string{"Tom", "Jack", "Sam"})
+		if err != nil || len(views) != len(db) {
+			t.Fatalf("node %d got %d values, err %v", i, len(views), err)
+		}
+		for k, v := range db {
+			if views[k].String() != v {
+				t.Fatalf("node %d got %s for %s, expected %s", i, views[k], k, v)
+			}
+		}
+	}
+}
+
+func TestGRPCGetMultiPartial(t *testing.T) {
+	pools, nodes := startGRPCCluster(t, "grpc_multi_partial_scores", 2, partialBatchGetter{}, WithHotCacheRatio(0))
+
+	// 找到 broken 的所属节点是远程节点的 node，以及和 broken 在同一批中的 key
+	node := 0
+	if _, ok := pools[0].PickPeer("broken"); !ok {
+		node = 1
+	}
+	var remote []string
+	for k := range db {
+		if _, ok := pools[node].PickPeer(k); ok {
+			remote = append(remote, k)
+		}
+	}
+	if len(remote) == 0 {
+		t.Skip("no key shares the batch with broken")
+	}
+
+	// 所属节点部分加载失败时，仍然通过 gRPC 返回成功的部分，不需要在本地重新加载
+	views, err := nodes[node].GetMulti(context.Background(), append(remote, "broken"))
+	if err == nil || len(views) != len(remote) {
+		t.Fatalf("got %v, %v", views, err)
+	}
+	if stats := nodes[node].Stats(); stats.PeerLoads != int64(len(remote)) || stats.LocalLoads != 0 {
+		t.Fatalf("values loaded by the owner should be returned, got %+v", stats)
+	}
+}
@@ -0,0 +1,187 @@
+package skycache
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// 节点的健康检查和熔断
+//
+// 连续失败 threshold 次后熔断器打开，PickPeer 跳过该节点，选择哈希环上的下一个节点。
+// 经过 cooldown 后进入半开状态，放行一个请求作为探测，成功则关闭，失败则重新打开
+
+const (
+	defaultBreakerThreshold = 5
+	defaultBreakerCooldown  = 10 * time.Second
+	healthPath              = "/healthz"
+)
+
+type breakerState int
+
+const (
+	breakerClosed   breakerState = iota //正常
+	breakerOpen                         //熔断，不放行请求
+	breakerHalfOpen                     //放行一个探测请求
+)
+
+func (s breakerState) String() string {
+	switch s {
+	case breakerClosed:
+		return "closed"
+	case breakerOpen:
+		return "open"
+	case breakerHalfOpen:
+		return "half-open"
+	}
+	return "unknown"
+}
+
+type breaker struct {
+	peer      string
+	threshold int           //连续失败多少次后打开，为 0 则不熔断
+	cooldown  time.Duration //打开后多久进入半开状态
+
+	mu       sync.Mutex
+	state    breakerState
+	failures int //连续失败的次数
+	openedAt time.Time
+	probing  bool //半开状态下，探测请求是否已经放行
+}
+
+func newBreaker(peer string, threshold int, cooldown time.Duration) *breaker {
+	return &breaker{peer: peer, threshold: threshold, cooldown: cooldown}
+}
+
+// 是否可以向该节点发送请求
+func (b *breaker) allow() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	switch b.state {
+	case breakerOpen:
+		if time.Since(b.openedAt) < b.cooldown {
+			return false
+		}
+		b.state = breakerHalfOpen
+		b.probing = true
+		return true
+	case breakerHalfOpen:
+		if b.probing {
+			return false
+		}
+		b.probing = true
+		return true
+	}
+	return true
+}
+
+// 和 allow 的判断相同，但不改变状态，只用于挑选节点
+// 挑选出的节点不一定会收到请求，真正发送前再调用 allow
+func (b *breaker) available() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	switch b.state {
+	case breakerOpen:
+		return time.Since(b.openedAt) >= b.cooldown
+	case breakerHalfOpen:
+		return !b.probing
+	}
+	return true
+}
+
+// 记录一次请求的结果
+func (b *breaker) record(err error) {
+	if b.threshold <= 0 {
+		return
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	// 调用方主动取消，不能说明节点是否健康，只归还探测名额
+	if errors.Is(err, context.Canceled) {
+		b.probing = false
+		return
+	}
+	if !isPeerFailure(err) {
+		if b.state != breakerClosed {
+			log.Printf("[GeeCache] peer %s recovered", b.peer)
+		}
+		b.state, b.failures, b.probing = breakerClosed, 0, false
+		return
+	}
+
+	b.failures++
+	if b.state == breakerHalfOpen || (b.state == breakerClosed && b.failures >= b.threshold) {
+		log.Printf("[GeeCache] peer %s is unhealthy after %d failures: %v", b.peer, b.failures, err)
+		b.state, b.openedAt, b.probing = breakerOpen, time.Now(), false
+	}
+}
+
+// 调用方的 ctx 已经结束，请求的结果不计入熔断器，只归还探测名额
+func (b *breaker) abort() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.probing = false
+}
+
+func (b *breaker) current() breakerState {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.state
+}
+
+// 只有节点本身的问题才算失败，key 不存在不算
+func isPeerFailure(err error) bool {
+	return err != nil && !errors.Is(err, ErrNotFound)
+}
+
+// 定期访问其他节点的 healthPath，结果同样记录到熔断器中
+func (p *Server) healthCheck(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	client := &http.Client{Timeout: interval}
+	for {
+		select {
+		case <-p.done:
+			return
+		case <-ticker.C:
+		}
+
+		p.mu.Lock()
+		breakers := make(map[string]*breaker, len(p.breakers))
+		for peer, b := range p.breakers {
+			if peer != p.addr {
+				breakers[peer] = b
+			}
+		}
+		p.mu.Unlock()
+
+		var wg sync.WaitGroup
+		for peer, b := range breakers {
+			wg.Add(1)
+			go func(peer string, b *breaker) {
+				defer wg.Done()
+				b.record(ping(client, peer+healthPath))
+			}(peer, b)
+		}
+		wg.Wait()
+	}
+}
+
+func ping(client *http.Client, url string) error {
+	res, err := client.Get(url)
+	if err != nil {
+		return err
+	}
+	res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return errors.New(res.Status)
+	}
+	return nil
+}
@@ -0,0 +1,169 @@
+package skycache
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	pb "skycache/skycachepb"
+	"testing"
+	"time"
+)
+
+func TestBreaker(t *testing.T) {
+	b := newBreaker("peer", 2, 50*time.Millisecond)
+	boom := errors.New("boom")
+
+	// key 不存在不算失败
+	b.record(&PeerError{Addr: "peer", Err: ErrNotFound})
+	b.record(boom)
+	if !b.allow() || b.current() != breakerClosed {
+		t.Fatal("breaker should stay closed after one failure")
+	}
+	b.record(boom)
+	if b.allow() || b.current() != breakerOpen {
+		t.Fatal("breaker should open after two failures")
+	}
+
+	// cooldown 后只放行一个探测请求
+	time.Sleep(60 * time.Millisecond)
+	if !b.allow() || b.allow() {
+		t.Fatal("half-open breaker should allow exactly one probe")
+	}
+	b.record(boom)
+	if b.current() != breakerOpen {
+		t.Fatal("failed probe should reopen the breaker")
+	}
+
+	time.Sleep(60 * time.Millisecond)
+	b.allow()
+	b.record(nil)
+	if !b.allow() || b.current() != breakerClosed {
+		t.Fatal("successful probe should close the breaker")
+	}
+}
+
+func TestBreakerCancel(t *testing.T) {
+	b := newBreaker("peer", 1, 10*time.Millisecond)
+	b.record(errors.New("boom"))
+
+	// 调用方取消的探测既不关闭也不重新打开熔断器，但会归还探测名额
+	time.Sleep(20 * time.Millisecond)
+	if !b.allow() {
+		t.Fatal("breaker should allow a probe after cooldown")
+	}
+	b.record(&PeerError{Addr: "peer", Err: context.Canceled})
+	if b.current() != breakerHalfOpen {
+		t.Fatalf("canceled probe should not change the state, got %v", b.current())
+	}
+	if !b.allow() {
+		t.Fatal("canceled probe should release the probe slot")
+	}
+
+	// 调用方超时同样不计入
+	b.abort()
+	if b.current() != breakerHalfOpen || !b.allow() {
+		t.Fatal("aborted probe should only release the probe slot")
+	}
+}
+
+func TestClientCallerDeadline(t *testing.T) {
+	block := make(chan struct{})
+	slow := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-block
+	}))
+	defer slow.Close()
+	defer close(block)
+
+	p := NewHTTPPool("http://self", WithCircuitBreaker(1, time.Hour))
+	p.Set("http://self", slow.URL)
+	c := p.httpGetters[slow.URL]
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if err := c.Get(ctx, &pb.Request{Group: "health_scores", Key: "Tom"}, &pb.Response{}); err == nil {
+		t.Fatal("request should time out")
+	}
+	if c.health.current() != breakerClosed || !c.health.available() {
+		t.Fatalf("caller deadline should not open the breaker, got %v", c.health.current())
+	}
+}
+
+func TestPickPeerSkipsUnhealthy(t *testing.T) {
+	alive := httptest.NewServer(NewHTTPPool("alive"))
+	defer alive.Close()
+	dead := "http://127.0.0.1:1" // 连接会被拒绝
+
+	p := NewHTTPPool("http://self", WithCircuitBreaker(1, time.Hour))
+	p.Set("http://self", dead, alive.URL)
+
+	// 找一个所属节点为 dead 的 key
+	key := ""
+	for i := 0; key == ""; i++ {
+		k := fmt.Sprint("key", i)
+		if peer, ok := p.PickPeer(k); ok && peer == p.httpGetters[dead] {
+			key = k
+		}
+	}
+
+	peer, _ := p.PickPeer(key)
+	if err := peer.Get(context.Background(), &pb.Request{Group: "health_scores", Key: key}, &pb.Response{}); err == nil {
+		t.Fatal("request to dead peer should fail")
+	}
+
+	// 熔断后选择哈希环上的下一个节点，可能是 alive 也可能是自己
+	peer, ok := p.PickPeer(key)
+	if ok && peer != p.httpGetters[alive.URL] {
+		t.Fatal("unhealthy peer should be skipped")
+	}
+}
+
+func TestPickReplicasKeepsBreaker(t *testing.T) {
+	p := NewHTTPPool("http://self", WithCircuitBreaker(1, 10*time.Millisecond))
+	p.Set("http://self", "http://a", "http://b")
+	a := p.httpGetters["http://a"]
+	a.health.record(errors.New("boom"))
+
+	// cooldown 之后 a 可以被选中，但只有真正发送请求时才进入半开状态
+	time.Sleep(20 * time.Millisecond)
+	for i := 0; i < 3; i++ {
+		found := false
+		for _, peer := range p.PickReplicas("Tom", 3) {
+			found = found || peer == a
+		}
+		if !found {
+			t.Fatal("a should be picked after cooldown")
+		}
+	}
+	if a.health.current() != breakerOpen {
+		t.Fatalf("PickReplicas should not change the breaker, got %v", a.health.current())
+	}
+	if !allowPeer(a) || allowPeer(a) {
+		t.Fatal("allowPeer should let exactly one probe through")
+	}
+}
+
+func TestHealthCheck(t *testing.T) {
+	alive := httptest.NewServer(NewHTTPPool("alive"))
+	defer alive.Close()
+	if res, err := http.Get(alive.URL + healthPath); err != nil || res.StatusCode != http.StatusOK {
+		t.Fatalf("healthz failed: %v", err)
+	}
+
+	dead := "http://127.0.0.1:1"
+	p := NewHTTPPool("http://self", WithCircuitBreaker(1, time.Hour), WithHealthCheck(10*time.Millisecond))
+	defer p.Close()
+	p.Set("http://self", dead, alive.URL)
+
+	deadline := time.Now().Add(time.Second)
+	for p.breakers[dead].current() != breakerOpen {
+		if time.Now().After(deadline) {
+			t.Fatal("health check should open the breaker of the dead peer")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	if p.breakers[alive.URL].current() != breakerClosed {
+		t.Fatal("alive peer should stay healthy")
+	}
+}
@@ -1,17 +1,31 @@
 package skycache
 
 import (
+	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"log"
 	"net/http"
 	"net/http/httptest"
+	"os"
+	"reflect"
+	"skycache/consistenthash"
+	"skycache/gossip"
+	pb "skycache/skycachepb"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
+	"time"
+
+	"google.golang.org/protobuf/proto"
 )
 
 func TestServeHTTP(t *testing.T) {
 	p := NewHTTPPool("localhost:xxx")
-	NewGroup("scores", 2<<10, GetterFunc(
+	NewGroup("http_scores", 2<<10, GetterFunc(
 		func(key string) ([]byte, error) {
 			log.Println("[SlowDB] search key", key)
 			if v, ok := db[key]; ok {
@@ -20,7 +34,7 @@ func TestServeHTTP(t *testing.T) {
 			return nil, fmt.Errorf("%s not exist", key)
 		}))
 
-	if groups["scores"] == nil {
+	if groups["http_scores"] == nil {
 		t.Fatal("newGroup error")
 	}
 
@@ -30,7 +44,7 @@ func TestServeHTTP(t *testing.T) {
 	//t.Log(server.URL)
 
 	base := server.URL + defaultBasePath
-	req1, err := http.NewRequest("GET", base+"scores/Tom", nil)
+	req1, err := http.NewRequest("GET", base+"http_scores/Tom", nil)
 	if err != nil {
 		t.Fatalf("creating request error: %s\n", err)
 	}
@@ -48,13 +62,137 @@ func TestServeHTTP(t *testing.T) {
 	if err != nil {
 		t.Fatalf("reading from response error: %s\n", err)
 	}
-	if string(buf) != db["Tom"] {
-		t.Errorf("result error,expected %s, got %s\n", db["Tom"], string(buf))
+	out := &pb.Response{}
+	if err = proto.Unmarshal(buf, out); err != nil {
+		t.Fatalf("decoding response error: %s\n", err)
+	}
+	if string(out.Value) != db["Tom"] {
+		t.Errorf("result error,expected %s, got %s\n", db["Tom"], string(out.Value))
+	}
+}
+
+// 在同一进程中启动 n 个节点，每个节点有自己的 Server 和 Group
+func startHTTPCluster(t *testing.T, name string, n int, getter Getter, opts ...GroupOption) ([]*Server, []*Group) {
+	servers := make([]*Server, n)
+	nodes := make([]*Group, n)
+	addrs := make([]string, n)
+	for i := 0; i < n; i++ {
+		ts := httptest.NewUnstartedServer(nil)
+		addrs[i] = "http://" + ts.Listener.Addr().String()
+
+		g := newGroup(name, 2<<10, getter, opts...)
+		p := NewHTTPPool(addrs[i])
+		p.groups = func(string) *Group { return g }
+		ts.Config.Handler = p
+		ts.Start()
+		t.Cleanup(ts.Close)
+
+		servers[i], nodes[i] = p, g
+	}
+
+	for i := range servers {
+		servers[i].Set(addrs...)
+		nodes[i].RegisterPeers(servers[i])
+	}
+	return servers, nodes
+}
+
+func TestDistributedSet(t *testing.T) {
+	_, nodes := startHTTPCluster(t, "set_scores", 3, GetterFunc(
+		func(key string) ([]byte, error) {
+			return []byte(db[key]), nil
+		}))
+
+	// 模拟每个节点上都有旧的副本
+	for _, g := range nodes {
+		g.mainCache.set("Tom", ByteView{b: []byte("stale")}, 0)
+	}
+
+	if err := nodes[0].Set(context.Background(), "Tom", ByteView{b: []byte("100")}); err != nil {
+		t.Fatalf("set Tom failed: %v", err)
+	}
+
+	for i, g := range nodes {
+		if v, err := g.Get(context.Background(), "Tom"); err != nil || v.String() != "100" {
+			t.Fatalf("node %d got %s for Tom after set, err %v", i, v, err)
+		}
+	}
+}
+
+func TestDistributedRemove(t *testing.T) {
+	_, nodes := startHTTPCluster(t, "remove_scores", 3, GetterFunc(
+		func(key string) ([]byte, error) {
+			return []byte(db[key]), nil
+		}))
+
+	for _, g := range nodes {
+		g.mainCache.set("Sam", ByteView{b: []byte("stale")}, 0)
+	}
+
+	if err := nodes[1].Remove(context.Background(), "Sam"); err != nil {
+		t.Fatalf("remove Sam failed: %v", err)
+	}
+
+	// 所有副本都被删除，重新从 getter 加载
+	for i, g := range nodes {
+		if _, ok := g.mainCache.get("Sam"); ok {
+			t.Fatalf("node %d still has a copy of Sam", i)
+		}
+	}
+	for i, g := range nodes {
+		if v, err := g.Get(context.Background(), "Sam"); err != nil || v.String() != db["Sam"] {
+			t.Fatalf("node %d got %s for Sam after remove, err %v", i, v, err)
+		}
+	}
+}
+
+func TestDeadlinePropagation(t *testing.T) {
+	deadlines := make(chan time.Time, 1)
+	_, nodes := startHTTPCluster(t, "deadline_scores", 2, ContextGetterFunc(
+		func(ctx context.Context, key string) ([]byte, error) {
+			if d, ok := ctx.Deadline(); ok {
+				select {
+				case deadlines <- d:
+				default:
+				}
+			}
+			return []byte(db[key]), nil
+		}), WithHotCacheRatio(0))
+
+	// 找一个所属节点是远程节点的 key
+	var g *Group
+	key := ""
+	for k := range db {
+		for _, node := range nodes {
+			if _, ok := node.peers.PickPeer(k); ok {
+				g, key = node, k
+			}
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if v, err := g.Get(ctx, key); err != nil || v.String() != db[key] {
+		t.Fatalf("get %s failed: %v %v", key, v, err)
+	}
+
+	select {
+	case d := <-deadlines:
+		// 截止时间以剩余时长的形式传递，允许有少量传输耗时的误差
+		if want, _ := ctx.Deadline(); d.Sub(want).Abs() > time.Second {
+			t.Fatalf("owner deadline %v, caller's %v", d, want)
+		}
+	default:
+		t.Fatal("owner did not receive a deadline")
 	}
 }
 
+// 手动启动一个节点用于调试，需要设置 SKYCACHE_SERVE 环境变量
 func TestMain(t *testing.T) {
-	NewGroup("scores", 2<<10, GetterFunc(
+	if os.Getenv("SKYCACHE_SERVE") == "" {
+		t.Skip("set SKYCACHE_SERVE to run a standalone server")
+	}
+	NewGroup("main_scores", 2<<10, GetterFunc(
 		func(key string) ([]byte, error) {
 			log.Println("[SlowDB] search key", key)
 			if v, ok := db[key]; ok {
@@ -68,3 +206,548 @@ func TestMain(t *testing.T) {
 	log.Println("geecache is running at", addr)
 	log.Fatal(http.ListenAndServe(addr, peers))
 }
+
+func TestNotFound(t *testing.T) {
+	var mu sync.Mutex
+	loads := make(map[string]int)
+	servers, nodes := startHTTPCluster(t, "notfound_scores", 2, GetterFunc(
+		func(key string) ([]byte, error) {
+			mu.Lock()
+			loads[key]++
+			mu.Unlock()
+			return nil, fmt.Errorf("%s not exist: %w", key, ErrNotFound)
+		}))
+
+	// 找一个所属节点不是 node 0 的 key
+	key := ""
+	for i := 0; key == ""; i++ {
+		if _, ok := servers[0].PickPeer(fmt.Sprint("missing", i)); ok {
+			key = fmt.Sprint("missing", i)
+		}
+	}
+
+	_, err := nodes[0].Get(context.Background(), key)
+	if !errors.Is(err, ErrNotFound) {
+		t.Fatalf("expected ErrNotFound, got %v", err)
+	}
+	var perr *PeerError
+	if !errors.As(err, &perr) || perr.Addr == "" {
+		t.Fatalf("expected *PeerError, got %T", err)
+	}
+	// 所属节点确认不存在后，不会再从本地加载
+	if loads[key] != 1 {
+		t.Fatalf("%s should be loaded once by its owner, got %d", key, loads[key])
+	}
+}
+
+func TestNegativeCacheRelay(t *testing.T) {
+	var mu sync.Mutex
+	loads := 0
+	servers, nodes := startHTTPCluster(t, "negative_relay_scores", 2, GetterFunc(
+		func(key string) ([]byte, error) {
+			mu.Lock()
+			loads++
+			mu.Unlock()
+			return nil, ErrNotFound
+		}), WithNegativeCache(time.Minute, 1<<10))
+
+	key := ""
+	for i := 0; key == ""; i++ {
+		if _, ok := servers[0].PickPeer(fmt.Sprint("missing", i)); ok {
+			key = fmt.Sprint("missing", i)
+		}
+	}
+
+	// 远程节点返回的不存在结果也会缓存在本地
+	for i := 0; i < 3; i++ {
+		if _, err := nodes[0].Get(context.Background(), key); !errors.Is(err, ErrNotFound) {
+			t.Fatalf("expected ErrNotFound, got %v", err)
+		}
+	}
+	if loads != 1 || nodes[0].Stats().NegativeHits != 2 {
+		t.Fatalf("expected 1 load and 2 negative hits, got %d loads, stats %+v", loads, nodes[0].Stats())
+	}
+	if n := nodes[0].CacheStats(NegativeCache).Items; n != 1 {
+		t.Fatalf("expected 1 negative entry on node 0, got %d", n)
+	}
+}
+
+func TestHTTPStatus(t *testing.T) {
+	tests := []error{
+		ErrNotFound,
+		fmt.Errorf("wrapped: %w", ErrNotFound),
+		context.DeadlineExceeded,
+	}
+	for _, err := range tests {
+		if got := errorFromHTTP(httpStatus(err), err.Error()); !errors.Is(err, got) {
+			t.Fatalf("%v is rebuilt as %v", err, got)
+		}
+	}
+	if httpStatus(errors.New("boom")) != http.StatusInternalServerError {
+		t.Fatal("unknown errors should map to 500")
+	}
+}
+
+func TestMembership(t *testing.T) {
+	release := make(chan struct{})
+	servers, nodes := startHTTPCluster(t, "membership_scores", 2, GetterFunc(
+		func(key string) ([]byte, error) {
+			<-release
+			return []byte(db[key]), nil
+		}), WithHotCacheRatio(0))
+
+	// 找一个所属节点为 node 1 的 key
+	key := ""
+	for i := 0; key == ""; i++ {
+		if _, ok := servers[0].PickPeer(fmt.Sprint("key", i)); ok {
+			key = fmt.Sprint("key", i)
+		}
+	}
+
+	done := make(chan error, 1)
+	go func() {
+		_, err := nodes[0].Get(context.Background(), key)
+		done <- err
+	}()
+	for nodes[1].Stats().ServerRequests == 0 {
+		time.Sleep(time.Millisecond)
+	}
+
+	// 移除 node 1 时，正在进行的请求会先完成
+	// drain 等到请求的响应返回为止，Get 之后才返回，所以这里检查请求在 RemovePeers 返回前已被放行
+	var released atomic.Bool
+	time.AfterFunc(20*time.Millisecond, func() {
+		released.Store(true)
+		close(release)
+	})
+	servers[0].RemovePeers(servers[1].addr)
+	if !released.Load() {
+		t.Fatal("RemovePeers returned before in-flight request finished")
+	}
+	if err := <-done; err != nil {
+		t.Fatalf("in-flight request failed: %v", err)
+	}
+
+	if peers := servers[0].Peers(); len(peers) != 1 || peers[0] != servers[0].addr {
+		t.Fatalf("unexpected peers %v", peers)
+	}
+	if _, ok := servers[0].PickPeer(key); ok {
+		t.Fatal("removed peer should not be picked")
+	}
+
+	servers[0].AddPeers(servers[1].addr, servers[1].addr)
+	if peers := servers[0].Peers(); len(peers) != 2 {
+		t.Fatalf("unexpected peers %v", peers)
+	}
+	if _, ok := servers[0].PickPeer(key); !ok {
+		t.Fatal("re-added peer should own the key again")
+	}
+
+	// 删除所有节点后不会 panic
+	servers[0].Set()
+	if _, ok := servers[0].PickPeer(key); ok {
+		t.Fatal("empty pool should not pick any peer")
+	}
+}
+
+func TestSetWeighted(t *testing.T) {
+	p := NewHTTPPool("http://self")
+	p.SetWeighted(map[string]int{"http://self": 1, "http://small": 1, "http://large": 4})
+
+	counts := make(map[string]int)
+	for i := 0; i < 10000; i++ {
+		peer := "http://self"
+		if c, ok := p.PickPeer(fmt.Sprint("key", i)); ok {
+			peer = c.(*Client).target
+		}
+		counts[strings.TrimSuffix(peer, p.basePath)]++
+	}
+	if counts["http://large"] < 2*counts["http://small"] {
+		t.Fatalf("large peer should own most keys: %v", counts)
+	}
+
+	// 调整权重不会重建连接，不在列表中的节点被移除
+	client := p.httpGetters["http://large"]
+	p.SetWeighted(map[string]int{"http://self": 1, "http://large": 2})
+	if p.httpGetters["http://large"] != client || p.peers.(*consistenthash.HashMap).Weight("http://large") != 2 {
+		t.Fatal("weight change should keep the existing client")
+	}
+	if peers := p.Peers(); len(peers) != 2 {
+		t.Fatalf("unexpected peers %v", peers)
+	}
+
+	// 权重 <= 0 的节点不会加入，已存在的被移除
+	p.SetWeighted(map[string]int{"http://self": 1, "http://large": 0, "http://new": -1})
+	if peers := p.Peers(); len(peers) != 1 || peers[0] != "http://self" {
+		t.Fatalf("peers with weight <= 0 should be dropped, got %v", peers)
+	}
+}
+
+func TestPlacement(t *testing.T) {
+	release := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+		body, _ := proto.Marshal(&pb.Response{Value: []byte("630")})
+		w.Write(body)
+	}))
+	defer server.Close()
+	peer, other := server.URL, "http://other"
+
+	bounded := consistenthash.NewBounded(50, 0.25, nil)
+	p := NewHTTPPool("http://self", WithPlacement(bounded))
+	p.Set("http://self", peer, other)
+
+	// 找一个所属节点为 peer、负载满了之后交给 other 的 key
+	key := ""
+	for i := 0; key == ""; i++ {
+		k := fmt.Sprint("key", i)
+		if nodes := consistenthash.GetN(bounded.Ring(), k, 2); nodes[0] == peer && nodes[1] == other {
+			key = k
+		}
+	}
+	target := func(c PeerGetter) string { return strings.TrimSuffix(c.(*Client).target, p.basePath) }
+
+	// 正在进行的请求计入节点的负载
+	done := make(chan error, 1)
+	go func() {
+		c, _ := p.PickReader(key)
+		done <- c.Get(context.Background(), &pb.Request{Group: "placement_scores", Key: key}, &pb.Response{})
+	}()
+	for bounded.Load(peer) != 1 {
+		time.Sleep(time.Millisecond)
+	}
+	close(release)
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+	if bounded.Load(peer) != 0 {
+		t.Fatal("load should drop after the request finished")
+	}
+
+	// 负载只影响读请求，所属节点仍由 Ring 决定，写入、副本和租约不受影响
+	for i := 0; i < 10; i++ {
+		bounded.Inc(peer)
+	}
+	if c, ok := p.PickReader(key); !ok || target(c) != other {
+		t.Fatalf("reads should move to %s when %s is full", other, peer)
+	}
+	if c, ok := p.PickPeer(key); !ok || target(c) != peer {
+		t.Fatalf("owner of %s should stay %s", key, peer)
+	}
+
+	// 其他节点发来的读请求直接交给所属节点，不会在节点之间来回转发
+	g := newGroup("placement_scores", 2<<10, GetterFunc(
+		func(key string) ([]byte, error) {
+			return []byte(db[key]), nil
+		}))
+	g.RegisterPeers(p)
+	if c, ok := g.pickReader(withPeerRequest(context.Background()), key); !ok || target(c) != peer {
+		t.Fatalf("peer requests should go to the owner %s", peer)
+	}
+
+	// 不支持权重的算法忽略权重
+	q := NewHTTPPool("http://self", WithPlacement(consistenthash.NewMaglev(0, nil)))
+	q.SetWeighted(map[string]int{"http://self": 1, peer: 3})
+	if peers := q.Peers(); len(peers) != 2 {
+		t.Fatalf("unexpected peers %v", peers)
+	}
+	picked := 0
+	for i := 0; i < 100; i++ {
+		if _, ok := q.PickPeer(fmt.Sprint("key", i)); ok {
+			picked++
+		}
+	}
+	if picked == 0 || picked == 100 {
+		t.Fatalf("maglev picked the peer for %d of 100 keys", picked)
+	}
+
+	// 不支持权重时，权重 <= 0 的节点同样不会加入
+	q.SetWeighted(map[string]int{"http://self": 1, peer: 0})
+	if peers := q.Peers(); len(peers) != 1 {
+		t.Fatalf("peer with weight 0 should be dropped, got %v", peers)
+	}
+}
+
+func TestGossipMembership(t *testing.T) {
+	servers, nodes := startHTTPCluster(t, "gossip_scores", 3, GetterFunc(
+		func(key string) ([]byte, error) {
+			return []byte(db[key]), nil
+		}))
+
+	// 每个节点一开始只知道自己，由 gossip 维护节点列表
+	members := make([]*gossip.Node, len(servers))
+	for i, p := range servers {
+		p.Set(p.addr)
+		node, err := gossip.New(gossip.Config{
+			Name:             p.addr,
+			BindAddr:         "127.0.0.1:0",
+			ProbeInterval:    20 * time.Millisecond,
+			SuspicionTimeout: 100 * time.Millisecond,
+			OnChange:         func(members []string) { p.Set(members...) },
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { node.Close() })
+		if i > 0 {
+			if err := node.Join(members[0].Addr()); err != nil {
+				t.Fatal(err)
+			}
+		}
+		members[i] = node
+	}
+
+	waitPeers := func(p *Server, n int) {
+		deadline := time.Now().Add(2 * time.Second)
+		for len(p.Peers()) != n {
+			if time.Now().After(deadline) {
+				t.Fatalf("%s expected %d peers, got %v", p.addr, n, p.Peers())
+			}
+			time.Sleep(5 * time.Millisecond)
+		}
+	}
+	for _, p := range servers {
+		waitPeers(p, 3)
+	}
+	for k, v := range db {
+		if view, err := nodes[0].Get(context.Background(), k); err != nil || view.String() != v {
+			t.Fatalf("failed to get %s: %v", k, err)
+		}
+	}
+
+	// 节点故障后自动从哈希环中移除
+	members[2].Close()
+	waitPeers(servers[0], 2)
+	waitPeers(servers[1], 2)
+}
+
+func TestAdmin(t *testing.T) {
+	p := NewHTTPPool("http://self", WithAdmin("/_skycache/peers", nil))
+	p.Set("http://self", "http://a")
+	ts := httptest.NewServer(p)
+	defer ts.Close()
+
+	do := func(method, body string) []string {
+		req, _ := http.NewRequest(method, ts.URL+"/_skycache/peers", strings.NewReader(body))
+		res, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer res.Body.Close()
+		if res.StatusCode != http.StatusOK {
+			t.Fatalf("%s returned %v", method, res.Status)
+		}
+		var out struct{ Peers []string }
+		if err := json.NewDecoder(res.Body).Decode(&out); err != nil {
+			t.Fatal(err)
+		}
+		return out.Peers
+	}
+
+	if got := do(http.MethodGet, ""); !reflect.DeepEqual(got, []string{"http://a", "http://self"}) {
+		t.Fatalf("GET returned %v", got)
+	}
+	if got := do(http.MethodPost, `{"peers":["http://b"]}`); !reflect.DeepEqual(got, []string{"http://a", "http://b", "http://self"}) {
+		t.Fatalf("POST returned %v", got)
+	}
+	if got := do(http.MethodDelete, `{"peers":["http://a"]}`); !reflect.DeepEqual(got, []string{"http://b", "http://self"}) {
+		t.Fatalf("DELETE returned %v", got)
+	}
+	if got := do(http.MethodPut, `{"peers":["http://self","http://c"]}`); !reflect.DeepEqual(got, []string{"http://c", "http://self"}) {
+		t.Fatalf("PUT returned %v", got)
+	}
+}
+
+func TestAdminAuth(t *testing.T) {
+	// 默认只允许本机访问
+	p := NewHTTPPool("http://self", WithAdmin("/_skycache/peers", nil))
+	req := httptest.NewRequest(http.MethodPut, "/_skycache/peers", strings.NewReader(`{"peers":["http://evil"]}`))
+	req.RemoteAddr = "192.0.2.1:1234"
+	rec := httptest.NewRecorder()
+	p.ServeHTTP(rec, req)
+	if rec.Code != http.StatusForbidden || len(p.Peers()) != 0 {
+		t.Fatalf("remote admin request should be forbidden, got %v, peers %v", rec.Code, p.Peers())
+	}
+
+	// authorize 决定是否允许
+	q := NewHTTPPool("http://self", WithAdmin("/_skycache/peers", func(r *http.Request) bool {
+		return r.Header.Get("Authorization") == "Bearer secret"
+	}))
+	for _, c := range []struct {
+		token string
+		code  int
+	}{{"", http.StatusForbidden}, {"Bearer secret", http.StatusOK}} {
+		req := httptest.NewRequest(http.MethodGet, "/_skycache/peers", nil)
+		req.Header.Set("Authorization", c.token)
+		rec := httptest.NewRecorder()
+		q.ServeHTTP(rec, req)
+		if rec.Code != c.code {
+			t.Fatalf("token %q: expected %v, got %v", c.token, c.code, rec.Code)
+		}
+	}
+}
+
+func TestDrain(t *testing.T) {
+	release := make(chan struct{})
+	peer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+	}))
+	defer peer.Close()
+
+	c := newClient(peer.URL+defaultBasePath, nil)
+	if !c.drain(time.Second) {
+		t.Fatal("idle client should drain immediately")
+	}
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		c.Get(context.Background(), &pb.Request{Group: "drain_scores", Key: "Tom"}, &pb.Response{})
+	}()
+	for {
+		c.mu.Lock()
+		n := c.inflight
+		c.mu.Unlock()
+		if n == 1 {
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	// 请求没有结束时等到超时，结束后立即返回
+	if c.drain(10 * time.Millisecond) {
+		t.Fatal("drain should time out while a request is in flight")
+	}
+	time.AfterFunc(10*time.Millisecond, func() { close(release) })
+	if !c.drain(time.Second) {
+		t.Fatal("drain should return once the request finished")
+	}
+	<-done
+}
+
+func TestWriteThroughReplicas(t *testing.T) {
+	servers, nodes := startHTTPCluster(t, "write_through_scores", 3, GetterFunc(
+		func(key string) ([]byte, error) {
+			return []byte(db[key]), nil
+		}), WithReplicas(2, true))
+
+	if err := nodes[0].Set(context.Background(), "Tom", ByteView{b: []byte("100")}); err != nil {
+		t.Fatalf("set Tom failed: %v", err)
+	}
+
+	// key 的两个所属节点上都有新的值，其他节点上没有
+	owners := consistenthash.GetN(servers[0].peers, "Tom", 2)
+	for i, g := range nodes {
+		v, ok := g.mainCache.get("Tom")
+		isOwner := servers[i].addr == owners[0] || servers[i].addr == owners[1]
+		if isOwner && (!ok || v.String() != "100") {
+			t.Fatalf("owner %d should hold the new value, got %s", i, v)
+		}
+		if !isOwner && ok {
+			t.Fatalf("node %d is not an owner, but holds %s", i, v)
+		}
+	}
+}
+
+func TestHotCache(t *testing.T) {
+	loads := 0
+	servers, nodes := startHTTPCluster(t, "hot_scores", 2, GetterFunc(
+		func(key string) ([]byte, error) {
+			loads++
+			return []byte(db[key]), nil
+		}), WithHotSampleRate(1))
+
+	// 找到一个所属节点不是 node 0 的 key
+	var key string
+	for k := range db {
+		if _, ok := servers[0].PickPeer(k); ok {
+			key = k
+			break
+		}
+	}
+	if key == "" {
+		t.Skip("all keys are owned by node 0")
+	}
+
+	for i := 0; i < 3; i++ {
+		if v, err := nodes[0].Get(context.Background(), key); err != nil || v.String() != db[key] {
+			t.Fatalf("failed to get %s, got %s, err %v", key, v, err)
+		}
+	}
+
+	// 第一次从远程节点获取，之后都命中 hotCache
+	stats := nodes[0].CacheStats(HotCache)
+	if stats.Gets != 3 || stats.Hits != 2 {
+		t.Fatalf("hotCache stats error, got %+v", stats)
+	}
+	if _, ok := nodes[0].mainCache.get(key); ok {
+		t.Fatalf("%s should not be in mainCache of node 0", key)
+	}
+	if loads != 1 {
+		t.Fatalf("%s should be loaded once, but loaded %d times", key, loads)
+	}
+
+	// 所属节点写入后，hotCache 中的副本失效
+	if err := nodes[1].Set(context.Background(), key, ByteView{b: []byte("100")}); err != nil {
+		t.Fatalf("set %s failed: %v", key, err)
+	}
+	if v, err := nodes[0].Get(context.Background(), key); err != nil || v.String() != "100" {
+		t.Fatalf("hotCache copy of %s should be invalidated, got %s", key, v)
+	}
+
+	// ratio 不小于 1 时 mainCache 没有容量，直接拒绝
+	for _, ratio := range []float64{-0.1, 1, 2} {
+		func() {
+			defer func() {
+				if recover() == nil {
+					t.Fatalf("WithHotCacheRatio should reject %v", ratio)
+				}
+			}()
+			WithHotCacheRatio(ratio)
+		}()
+	}
+}
+
+func TestGetMulti(t *testing.T) {
+	var mu sync.Mutex
+	loadCounts := make(map[string]int, len(db))
+	_, nodes := startHTTPCluster(t, "multi_scores", 3, GetterFunc(
+		func(key string) ([]byte, error) {
+			mu.Lock()
+			defer mu.Unlock()
+			loadCounts[key]++
+			if v, ok := db[key]; ok {
+				return []byte(v), nil
+			}
+			if key == "broken" {
+				return nil, errors.New("db is down")
+			}
+			return nil, fmt.Errorf("%s: %w", key, ErrNotFound)
+		}))
+
+	// 不存在的 key 从结果中省略，不算作错误
+	keys := []string{"Tom", "Jack", "Sam", "Tom", "unknown"}
+	for i, g := range nodes {
+		views, err := g.GetMulti(context.Background(), keys)
+		if err != nil || len(views) != len(db) {
+			t.Fatalf("node %d got %d values, expected %d, err %v", i, len(views), len(db), err)
+		}
+		for k, v := range db {
+			if views[k].String() != v {
+				t.Fatalf("node %d got %s for %s, expected %s", i, views[k], k, v)
+			}
+		}
+	}
+
+	for k := range db {
+		if loadCounts[k] != 1 {
+			t.Fatalf("%s should be loaded once, but loaded %d times", k, loadCounts[k])
+		}
+	}
+
+	// 其他错误不会被吞掉，所属节点返回错误后在本地重新加载
+	for i, g := range nodes {
+		if _, err := g.GetMulti(context.Background(), []string{"broken"}); err == nil {
+			t.Fatalf("node %d should return the load error", i)
+		}
+	}
+}
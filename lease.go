@@ -0,0 +1,213 @@
+package skycache
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"math/rand"
+	pb "skycache/skycachepb"
+	"sync"
+	"time"
+)
+
+// 集群级别的加载租约
+//
+// singleflight 只能合并同一个节点内的请求。所属节点不可用或加载失败时，每个节点都会自己加载，
+// N 个节点同时访问数据源。开启租约后，非所属节点加载前先向租约节点申请租约，
+// 租约节点是哈希环上所属节点之后第一个可用的节点，所有节点选出的租约节点相同：
+//   - 拿到租约的节点加载，然后把值作为副本写入租约节点，租约随之结束
+//   - 其他节点轮询租约节点，直到拿到值，或者持有者归还/租约过期后自己拿到租约
+//   - 持有者确认 key 不存在时，在租约的剩余时间内其他节点直接得到 ErrNotFound
+//
+// 每个租约有一个 token，只有带上 token 的持有者可以归还或结束租约，
+// 租约过期后重新发放给其他节点时，原来的持有者不会误删新的租约
+
+const (
+	leaseSweepInterval = time.Second
+	leasePollDivisor   = 10               //轮询间隔为租约时长的 1/10
+	maxLeaseTTL        = 30 * time.Second //没有设置 WithLoadLease 时，租约节点允许的最长租约
+)
+
+type leaseEntry struct {
+	token    uint64
+	expires  time.Time
+	notFound bool //持有者确认 key 不存在
+}
+
+// 租约节点上正在进行的租约
+type leaseTable struct {
+	mu        sync.Mutex
+	m         map[string]*leaseEntry
+	lastSweep time.Time
+}
+
+// 没有未过期的租约时发放给申请者，返回的 token 不为 0
+func (t *leaseTable) acquire(key string, ttl time.Duration) (token uint64, notFound bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	now := time.Now()
+	if t.m == nil {
+		t.m = make(map[string]*leaseEntry)
+	}
+	t.sweep(now)
+	if e, ok := t.m[key]; ok && now.Before(e.expires) {
+		return 0, e.notFound
+	}
+	for token == 0 {
+		token = rand.Uint64()
+	}
+	t.m[key] = &leaseEntry{token: token, expires: now.Add(ttl)}
+	return token, false
+}
+
+// 持有者加载失败时归还，key 不存在时保留记录到租约过期，告知其他申请者
+// token 不匹配说明租约已经过期并发放给了别人，忽略
+func (t *leaseTable) release(key string, token uint64, notFound bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	e, ok := t.m[key]
+	if !ok || e.token != token {
+		return
+	}
+	if notFound {
+		e.notFound = true
+		return
+	}
+	delete(t.m, key)
+}
+
+// 持有者已经写入值，不再需要租约
+func (t *leaseTable) done(key string, token uint64) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if e, ok := t.m[key]; ok && e.token == token {
+		delete(t.m, key)
+	}
+}
+
+// 清理持有者没有归还的过期租约
+func (t *leaseTable) sweep(now time.Time) {
+	if now.Sub(t.lastSweep) < leaseSweepInterval {
+		return
+	}
+	t.lastSweep = now
+	for key, e := range t.m {
+		if !now.Before(e.expires) {
+			delete(t.m, key)
+		}
+	}
+}
+
+// 作为租约节点处理申请和归还
+func (g *Group) grantLease(in *pb.LeaseRequest) *pb.LeaseResponse {
+	if in.Release {
+		g.leases.release(in.Key, in.Token, in.NotFound)
+		return &pb.LeaseResponse{}
+	}
+	if v, ok := g.mainCache.get(in.Key); ok {
+		return &pb.LeaseResponse{Found: true, Value: v.ByteSlice()}
+	}
+	if g.negativeTTL > 0 {
+		if _, ok := g.negCache.get(in.Key); ok {
+			return &pb.LeaseResponse{NotFound: true}
+		}
+	}
+	token, notFound := g.leases.acquire(in.Key, g.clampLeaseTTL(time.Duration(in.Ttl)))
+	return &pb.LeaseResponse{Granted: token != 0, NotFound: notFound, Token: token}
+}
+
+// 租约时长由申请者决定，限制在本节点的 leaseTTL 以内，避免 key 被长时间锁住
+func (g *Group) clampLeaseTTL(ttl time.Duration) time.Duration {
+	limit := g.leaseTTL
+	if limit <= 0 {
+		limit = maxLeaseTTL
+	}
+	if ttl <= 0 || ttl > limit {
+		return limit
+	}
+	return ttl
+}
+
+// 需要自己加载时，先申请租约，保证整个集群只有一个节点从数据源加载 key
+func (g *Group) loadWithLease(ctx context.Context, key string) (ByteView, error) {
+	lp, ok := g.peers.(LeasePicker)
+	if g.leaseTTL <= 0 || !ok {
+		return g.getLocally(ctx, key)
+	}
+	leaser, self, ok := lp.PickLeaser(key)
+	if !ok {
+		return g.getLocally(ctx, key)
+	}
+
+	ask := func(in *pb.LeaseRequest) (*pb.LeaseResponse, error) {
+		in.Group, in.Key = g.name, key
+		if self {
+			return g.grantLease(in), nil
+		}
+		out := &pb.LeaseResponse{}
+		return out, leaser.Lease(ctx, in, out)
+	}
+
+	for {
+		resp, err := ask(&pb.LeaseRequest{Ttl: int64(g.leaseTTL)})
+		if err != nil {
+			// 租约节点也不可用，只能自己加载
+			log.Printf("[Cache %s] failed to acquire lease of %s: %v", g.name, key, err)
+			return g.getLocally(ctx, key)
+		}
+
+		switch {
+		case resp.Granted:
+			return g.loadAsLeaseHolder(ctx, key, resp.Token, leaser, self, ask)
+		case resp.Found:
+			g.stats.leaseWaits.Add(1)
+			value := ByteView{b: resp.Value}
+			g.populateHotCache(key, value)
+			return value, nil
+		case resp.NotFound:
+			g.stats.leaseWaits.Add(1)
+			g.populateNegCache(key)
+			return ByteView{}, fmt.Errorf("%s: %w", key, ErrNotFound)
+		}
+
+		select {
+		case <-ctx.Done():
+			return ByteView{}, ctx.Err()
+		case <-time.After(g.leaseTTL / leasePollDivisor):
+		}
+	}
+}
+
+// 持有租约时加载，成功则把值写入租约节点，失败则归还租约
+func (g *Group) loadAsLeaseHolder(ctx context.Context, key string, token uint64, leaser PeerLeaser, self bool,
+	ask func(*pb.LeaseRequest) (*pb.LeaseResponse, error)) (ByteView, error) {
+	value, err := g.getLocally(ctx, key)
+	if err != nil {
+		release := &pb.LeaseRequest{Release: true, NotFound: errors.Is(err, ErrNotFound), Token: token}
+		if _, rerr := ask(release); rerr != nil {
+			log.Printf("[Cache %s] failed to release lease of %s: %v", g.name, key, rerr)
+		}
+		return value, err
+	}
+
+	if self {
+		// getLocally 已经写入了 mainCache
+		g.leases.done(key, token)
+		return value, nil
+	}
+	err = leaser.Set(ctx, &pb.SetRequest{
+		Group:   g.name,
+		Key:     key,
+		Value:   value.ByteSlice(),
+		Ttl:     int64(g.ttl),
+		Replica: true,
+		Lease:   token,
+	})
+	if err != nil {
+		// 其他节点等到租约过期后会自己加载
+		log.Printf("[Cache %s] failed to publish %s to leaser: %v", g.name, key, err)
+	}
+	return value, nil
+}
@@ -0,0 +1,173 @@
+package skycache
+
+import (
+	"context"
+	"errors"
+	pb "skycache/skycachepb"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestGrantLease(t *testing.T) {
+	g := newGroup("grant_scores", 2<<10, GetterFunc(
+		func(key string) ([]byte, error) {
+			return []byte(db[key]), nil
+		}), WithNegativeCache(time.Minute, 1<<10))
+	acquire := func(key string, ttl time.Duration) *pb.LeaseResponse {
+		return g.grantLease(&pb.LeaseRequest{Group: g.name, Key: key, Ttl: int64(ttl)})
+	}
+
+	// 同一时刻只有一个申请者拿到租约，持有者归还后可以再次发放
+	lease := acquire("Tom", time.Minute)
+	if !lease.Granted || lease.Token == 0 || acquire("Tom", time.Minute).Granted {
+		t.Fatal("lease should be granted exactly once")
+	}
+	g.grantLease(&pb.LeaseRequest{Key: "Tom", Release: true, Token: lease.Token + 1})
+	if acquire("Tom", time.Minute).Granted {
+		t.Fatal("release without the holder's token should be ignored")
+	}
+	g.grantLease(&pb.LeaseRequest{Key: "Tom", Release: true, Token: lease.Token})
+	if !acquire("Tom", time.Minute).Granted {
+		t.Fatal("released lease should be granted again")
+	}
+
+	// 持有者写入副本后，其他申请者直接拿到值
+	g.setAsReplica("Tom", ByteView{b: []byte("630")}, 0)
+	if resp := acquire("Tom", time.Minute); !resp.Found || string(resp.Value) != "630" {
+		t.Fatalf("expected published value, got %+v", resp)
+	}
+
+	// 持有者确认 key 不存在
+	lease = acquire("unknown", time.Minute)
+	g.grantLease(&pb.LeaseRequest{Key: "unknown", Release: true, NotFound: true, Token: lease.Token})
+	if resp := acquire("unknown", time.Minute); resp.Granted || !resp.NotFound {
+		t.Fatalf("expected not found, got %+v", resp)
+	}
+
+	// 持有者没有归还，租约过期后发放给下一个申请者，原来的持有者不能再归还或结束它
+	stale := acquire("Jack", 10*time.Millisecond)
+	time.Sleep(20 * time.Millisecond)
+	if !acquire("Jack", time.Minute).Granted {
+		t.Fatal("expired lease should be granted again")
+	}
+	g.grantLease(&pb.LeaseRequest{Key: "Jack", Release: true, Token: stale.Token})
+	g.leases.done("Jack", stale.Token)
+	if acquire("Jack", time.Minute).Granted {
+		t.Fatal("stale holder should not end the new lease")
+	}
+
+	// 租约时长不能超过 maxLeaseTTL
+	acquire("Sam", time.Hour)
+	g.leases.mu.Lock()
+	expires := g.leases.m["Sam"].expires
+	g.leases.mu.Unlock()
+	if time.Until(expires) > maxLeaseTTL {
+		t.Fatalf("lease ttl should be clamped to %v, got %v", maxLeaseTTL, time.Until(expires))
+	}
+}
+
+// 所属节点加载失败时，其他节点通过租约只加载一次
+func testLoadLease(t *testing.T, nodes []*Group, owner int) {
+	var calls atomic.Int32
+	for i, g := range nodes {
+		if i == owner {
+			g.getter = GetterFunc(func(key string) ([]byte, error) {
+				return nil, errors.New("db unavailable")
+			})
+			continue
+		}
+		g.getter = GetterFunc(func(key string) ([]byte, error) {
+			calls.Add(1)
+			time.Sleep(50 * time.Millisecond)
+			return []byte(db[key]), nil
+		})
+	}
+
+	var wg sync.WaitGroup
+	for i, g := range nodes {
+		if i == owner {
+			continue
+		}
+		wg.Add(1)
+		go func(g *Group) {
+			defer wg.Done()
+			if v, err := g.Get(context.Background(), "Tom"); err != nil || v.String() != "630" {
+				t.Errorf("Get = %v, %v", v, err)
+			}
+		}(g)
+	}
+	wg.Wait()
+
+	waits := int64(0)
+	for _, g := range nodes {
+		waits += g.Stats().LeaseWaits
+	}
+	if n := calls.Load(); n != 1 || waits != int64(len(nodes)-2) {
+		t.Fatalf("expected 1 load and %d lease waits, got %d loads and %d waits", len(nodes)-2, n, waits)
+	}
+}
+
+func TestLoadLease(t *testing.T) {
+	servers, nodes := startHTTPCluster(t, "lease_scores", 3, GetterFunc(
+		func(key string) ([]byte, error) {
+			return []byte(db[key]), nil
+		}), WithLoadLease(time.Second), WithHotCacheRatio(0))
+
+	addr, _ := servers[0].peers.Get("Tom")
+	for i, p := range servers {
+		if p.addr == addr {
+			testLoadLease(t, nodes, i)
+		}
+	}
+}
+
+func TestGRPCLoadLease(t *testing.T) {
+	pools, nodes := startGRPCCluster(t, "grpc_lease_scores", 3, GetterFunc(
+		func(key string) ([]byte, error) {
+			return []byte(db[key]), nil
+		}), WithLoadLease(time.Second), WithHotCacheRatio(0))
+
+	addr, _ := pools[0].peers.Get("Tom")
+	for i, p := range pools {
+		if p.addr == addr {
+			testLoadLease(t, nodes, i)
+		}
+	}
+}
+
+// 持有者加载失败后通过 gRPC 归还租约，租约节点不需要等到租约过期
+func TestGRPCLeaseRelease(t *testing.T) {
+	const ttl = 3 * time.Second
+	pools, nodes := startGRPCCluster(t, "grpc_lease_release_scores", 3, GetterFunc(
+		func(key string) ([]byte, error) {
+			return nil, errors.New("db unavailable")
+		}), WithLoadLease(ttl), WithHotCacheRatio(0))
+
+	holder, leaser := -1, -1
+	for i, p := range pools {
+		if _, self, ok := p.PickLeaser("Tom"); ok && self {
+			leaser = i
+		} else if ok {
+			holder = i
+		}
+	}
+	if holder < 0 || leaser < 0 {
+		t.Fatalf("unexpected placement: holder %d, leaser %d", holder, leaser)
+	}
+	nodes[leaser].getter = GetterFunc(func(key string) ([]byte, error) {
+		return []byte(db[key]), nil
+	})
+
+	if _, err := nodes[holder].Get(context.Background(), "Tom"); err == nil {
+		t.Fatal("holder should fail to load Tom")
+	}
+	start := time.Now()
+	if v, err := nodes[leaser].Get(context.Background(), "Tom"); err != nil || v.String() != "630" {
+		t.Fatalf("Get = %v, %v", v, err)
+	}
+	if d := time.Since(start); d > ttl/2 {
+		t.Fatalf("released lease should be granted at once, waited %v", d)
+	}
+}
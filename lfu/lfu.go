@@ -0,0 +1,151 @@
+package lfu
+
+import (
+	"container/list"
+	"skycache/eviction"
+	"time"
+)
+
+var _ eviction.Cache = (*Cache)(nil)
+
+// LFU Cache，淘汰访问次数最少的记录，次数相同时淘汰最久未访问的
+// 所有操作都是 O(1) 的：freqs 按访问次数从小到大排列，每个频次节点下挂着一条 lru 链表
+type Cache struct {
+	maxBytes  int64                    //最大容量，为 0 则无上限
+	nBytes    int64                    //当前容量
+	freqs     *list.List               //频次节点链表，元素为 *freqNode
+	cache     map[string]*list.Element //key -> 所在频次节点下的元素
+	OnEvicted eviction.OnEvicted       //回调函数，可选
+}
+
+type freqNode struct {
+	freq  int
+	items *list.List //元素为 *entry，队首为最近访问
+}
+
+type entry struct {
+	key    string
+	value  eviction.Value
+	expire time.Time     //过期时间，零值表示永不过期
+	node   *list.Element //所属的频次节点
+}
+
+func New(maxBytes int64, onEvicted eviction.OnEvicted) *Cache {
+	return &Cache{
+		maxBytes:  maxBytes,
+		freqs:     list.New(),
+		cache:     make(map[string]*list.Element),
+		OnEvicted: onEvicted,
+	}
+}
+
+// 查询，命中时访问次数 +1
+func (c *Cache) Get(key string) (eviction.Value, bool) {
+	if e, ok := c.cache[key]; ok {
+		kv := e.Value.(*entry)
+		if eviction.IsExpired(kv.expire, time.Now()) {
+			c.removeElement(e, eviction.Expired)
+			return nil, false
+		}
+		c.increment(e)
+		return kv.value, true
+	}
+	return nil, false
+}
+
+func (c *Cache) Set(key string, value eviction.Value) {
+	c.SetWithTTL(key, value, 0)
+}
+
+// 写入，更新已有记录视为一次访问
+func (c *Cache) SetWithTTL(key string, value eviction.Value, ttl time.Duration) {
+	expire := eviction.Deadline(ttl)
+
+	if e, ok := c.cache[key]; ok {
+		kv := e.Value.(*entry)
+		c.nBytes += int64(value.Len()) - int64(kv.value.Len())
+		kv.value = value
+		kv.expire = expire
+		c.increment(e)
+	} else {
+		// 新记录的访问次数为 1
+		front := c.freqs.Front()
+		if front == nil || front.Value.(*freqNode).freq != 1 {
+			front = c.freqs.PushFront(&freqNode{freq: 1, items: list.New()})
+		}
+		kv := &entry{key: key, value: value, expire: expire, node: front}
+		c.cache[key] = front.Value.(*freqNode).items.PushFront(kv)
+		c.nBytes += int64(len(key) + value.Len())
+	}
+
+	for c.maxBytes != 0 && c.nBytes > c.maxBytes {
+		c.removeLeast()
+	}
+}
+
+// 把 e 移到下一个频次节点
+func (c *Cache) increment(e *list.Element) {
+	kv := e.Value.(*entry)
+	cur := kv.node
+	node := cur.Value.(*freqNode)
+
+	next := cur.Next()
+	if next == nil || next.Value.(*freqNode).freq != node.freq+1 {
+		next = c.freqs.InsertAfter(&freqNode{freq: node.freq + 1, items: list.New()}, cur)
+	}
+
+	node.items.Remove(e)
+	kv.node = next
+	c.cache[kv.key] = next.Value.(*freqNode).items.PushFront(kv)
+
+	if node.items.Len() == 0 {
+		c.freqs.Remove(cur)
+	}
+}
+
+// 淘汰访问次数最少且最久未访问的一项
+func (c *Cache) removeLeast() {
+	if front := c.freqs.Front(); front != nil {
+		c.removeElement(front.Value.(*freqNode).items.Back(), eviction.Evicted)
+	}
+}
+
+func (c *Cache) Remove(key string) {
+	if e, ok := c.cache[key]; ok {
+		c.removeElement(e, eviction.Removed)
+	}
+}
+
+func (c *Cache) RemoveExpired() int {
+	now := time.Now()
+	n := 0
+	for _, e := range c.cache {
+		if eviction.IsExpired(e.Value.(*entry).expire, now) {
+			c.removeElement(e, eviction.Expired)
+			n++
+		}
+	}
+	return n
+}
+
+func (c *Cache) removeElement(e *list.Element, reason eviction.EvictReason) {
+	kv := e.Value.(*entry)
+	node := kv.node.Value.(*freqNode)
+	node.items.Remove(e)
+	if node.items.Len() == 0 {
+		c.freqs.Remove(kv.node)
+	}
+	delete(c.cache, kv.key)
+	c.nBytes -= int64(len(kv.key) + kv.value.Len())
+	if c.OnEvicted != nil {
+		c.OnEvicted(kv.key, kv.value, reason)
+	}
+}
+
+func (c *Cache) Len() int {
+	return len(c.cache)
+}
+
+func (c *Cache) Bytes() int64 {
+	return c.nBytes
+}
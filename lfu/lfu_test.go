@@ -0,0 +1,70 @@
+package lfu_test
+
+import (
+	"reflect"
+	"skycache/eviction"
+	"skycache/lfu"
+	"testing"
+	"time"
+)
+
+type String string
+
+func (s String) Len() int {
+	return len(s)
+}
+
+func TestGet(t *testing.T) {
+	c := lfu.New(0, nil)
+	c.Set("1", String("val1"))
+	if v, ok := c.Get("1"); !ok || string(v.(String)) != "val1" {
+		t.Fatalf("cache hit key 1 failed, expecetd val1, got %v", v)
+	}
+
+	if v, ok := c.Get("2"); ok {
+		t.Fatalf("cache hit key 2 failed, expected nil, got %v", v)
+	}
+}
+
+func TestRemoveLeast(t *testing.T) {
+	keys := make([]string, 0)
+	callback := func(key string, value eviction.Value, reason eviction.EvictReason) {
+		keys = append(keys, key)
+	}
+
+	c := lfu.New(int64(12), callback)
+	c.Set("k1", String("v1"))
+	c.Set("k2", String("v2"))
+	c.Set("k3", String("v3"))
+
+	// k1 访问 3 次，k3 访问 2 次，k2 只访问 1 次
+	c.Get("k1")
+	c.Get("k1")
+	c.Get("k3")
+
+	c.Set("k4", String("v4"))
+	// 频次相同时，淘汰最久未访问的
+	c.Set("k5", String("v5"))
+
+	if !reflect.DeepEqual([]string{"k2", "k4"}, keys) {
+		t.Fatalf("Call OnEvicted failed, expect [k2 k4], got %v", keys)
+	}
+	if c.Len() != 3 || c.Bytes() != 12 {
+		t.Fatalf("cache size error, len %d bytes %d", c.Len(), c.Bytes())
+	}
+}
+
+func TestSetWithTTL(t *testing.T) {
+	c := lfu.New(0, nil)
+	c.SetWithTTL("k1", String("val1"), 10*time.Millisecond)
+	c.Set("k2", String("val2"))
+	c.Get("k2")
+
+	time.Sleep(20 * time.Millisecond)
+	if n := c.RemoveExpired(); n != 1 {
+		t.Fatalf("RemoveExpired should remove 1 entry, but %d removed", n)
+	}
+	if _, ok := c.Get("k2"); !ok || c.Len() != 1 {
+		t.Fatalf("k2 should never expire")
+	}
+}
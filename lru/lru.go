@@ -1,26 +1,41 @@
 package lru
 
-import "container/list"
+import (
+	"container/list"
+	"skycache/eviction"
+	"time"
+)
+
+var _ eviction.Cache = (*Cache)(nil)
 
 //LRU Cache
 type Cache struct {
-	maxBytes  int64                         //最大容量，为 0 则无上限(表现在不会主动淘汰记录)
-	nBytes    int64                         //当前容量
-	ll        *list.List                    //存储value
-	cache     map[string]*list.Element      //存储key-value对
-	OnEvicted func(key string, value Value) //回调函数，可选
+	maxBytes  int64                                             //最大容量，为 0 则无上限(表现在不会主动淘汰记录)
+	nBytes    int64                                             //当前容量
+	ll        *list.List                                        //存储value
+	cache     map[string]*list.Element                          //存储key-value对
+	OnEvicted func(key string, value Value, reason EvictReason) //回调函数，可选
 }
 
 type entry struct {
-	key   string
-	value Value
+	key    string
+	value  Value
+	expire time.Time //过期时间，零值表示永不过期
 }
 
-type Value interface {
-	Len() int
-}
+// 兼容旧的定义，实际定义在 eviction 包中
+type (
+	Value       = eviction.Value
+	EvictReason = eviction.EvictReason
+)
+
+const (
+	Expired = eviction.Expired
+	Evicted = eviction.Evicted
+	Removed = eviction.Removed
+)
 
-func New(maxBytes int64, OnEvicted func(string, Value)) *Cache {
+func New(maxBytes int64, OnEvicted func(string, Value, EvictReason)) *Cache {
 	return &Cache{
 		maxBytes:  maxBytes,
 		ll:        list.New(),
@@ -29,11 +44,15 @@ func New(maxBytes int64, OnEvicted func(string, Value)) *Cache {
 	}
 }
 
-// 查询，视为一次使用，更新其位置
+// 查询，视为一次使用，更新其位置；已过期的记录视为未命中
 func (c *Cache) Get(key string) (Value, bool) {
 	if e, ok := c.cache[key]; ok {
-		c.ll.MoveToFront(e) //移到队首
 		kv := e.Value.(*entry)
+		if eviction.IsExpired(kv.expire, time.Now()) {
+			c.removeElement(e, Expired)
+			return nil, false
+		}
+		c.ll.MoveToFront(e) //移到队首
 		return kv.value, ok
 	}
 	return nil, false
@@ -41,14 +60,23 @@ func (c *Cache) Get(key string) (Value, bool) {
 
 //写入，可能是新增/删除，同样需要更新位置
 func (c *Cache) Set(key string, value Value) {
+	c.SetWithTTL(key, value, 0)
+}
+
+// 写入并设置过期时间，ttl <= 0 表示永不过期
+func (c *Cache) SetWithTTL(key string, value Value, ttl time.Duration) {
+	expire := eviction.Deadline(ttl)
+
 	if e, ok := c.cache[key]; ok {
 		c.ll.MoveToFront(e)
 		kv := e.Value.(*entry)
-		kv.value = value
+		// ! 先计算差值再覆盖旧值
 		c.nBytes += (int64(value.Len()) - int64(kv.value.Len()))
+		kv.value = value
+		kv.expire = expire
 	} else {
 		// ! 记得和上面保持一致，e.Value 是 *entry 类型
-		e := c.ll.PushFront(&entry{key: key, value: value})
+		e := c.ll.PushFront(&entry{key: key, value: value, expire: expire})
 		c.cache[key] = e
 
 		// ? maybe += int64(len(e)) is better
@@ -62,30 +90,47 @@ func (c *Cache) Set(key string, value Value) {
 
 //根据 lru 策略，淘汰最近最少使用的一项
 func (c *Cache) removeOldest() {
-	e := c.ll.Back()
-	if e != nil {
-		c.ll.Remove(e)
-		kv := e.Value.(*entry)
-		delete(c.cache, kv.key)
-		c.nBytes -= int64(len(kv.key) + kv.value.Len())
-		if c.OnEvicted != nil {
-			c.OnEvicted(kv.key, kv.value)
-		}
+	if e := c.ll.Back(); e != nil {
+		c.removeElement(e, Evicted)
 	}
 }
 
 func (c *Cache) Remove(key string) {
 	if e, ok := c.cache[key]; ok {
-		kv := e.Value.(*entry)
-		c.ll.Remove(e)
-		delete(c.cache, key)
-		c.nBytes -= int64(len(kv.key) + kv.value.Len())
-		if c.OnEvicted != nil {
-			c.OnEvicted(kv.key, kv.value)
+		c.removeElement(e, Removed)
+	}
+}
+
+// 清除所有已过期的记录，返回清除的数量
+func (c *Cache) RemoveExpired() int {
+	now := time.Now()
+	n := 0
+	for e := c.ll.Back(); e != nil; {
+		prev := e.Prev()
+		if eviction.IsExpired(e.Value.(*entry).expire, now) {
+			c.removeElement(e, Expired)
+			n++
 		}
+		e = prev
+	}
+	return n
+}
+
+func (c *Cache) removeElement(e *list.Element, reason EvictReason) {
+	kv := e.Value.(*entry)
+	c.ll.Remove(e)
+	delete(c.cache, kv.key)
+	c.nBytes -= int64(len(kv.key) + kv.value.Len())
+	if c.OnEvicted != nil {
+		c.OnEvicted(kv.key, kv.value, reason)
 	}
 }
 
 func (c *Cache) Len() int {
 	return c.ll.Len()
 }
+
+// 当前占用的字节数
+func (c *Cache) Bytes() int64 {
+	return c.nBytes
+}
@@ -4,6 +4,7 @@ import (
 	"reflect"
 	"skycache/lru"
 	"testing"
+	"time"
 )
 
 type String string
@@ -61,7 +62,10 @@ func TestRemoveOldest(t *testing.T) {
 
 func TestOnEvicted(t *testing.T) {
 	keys := make([]string, 0)
-	callback := func(key string, value lru.Value) {
+	callback := func(key string, value lru.Value, reason lru.EvictReason) {
+		if reason != lru.Evicted {
+			t.Fatalf("key %s evicted for %s, expected evicted", key, reason)
+		}
 		keys = append(keys, key)
 	}
 
@@ -77,3 +81,68 @@ func TestOnEvicted(t *testing.T) {
 		t.Fatalf("Call OnEvicted failed, expect keys equals to %s", expect)
 	}
 }
+
+func TestSetWithTTL(t *testing.T) {
+	c := lru.New(0, nil)
+	c.SetWithTTL("k1", String("val1"), 20*time.Millisecond)
+	c.Set("k2", String("val2"))
+
+	if _, ok := c.Get("k1"); !ok {
+		t.Fatalf("cache hit k1 failed before expiration")
+	}
+
+	time.Sleep(30 * time.Millisecond)
+
+	// k1 已过期，视为未命中，且被清除
+	if v, ok := c.Get("k1"); ok {
+		t.Fatalf("k1 should be expired, but got %s", v.(String))
+	}
+	if _, ok := c.Get("k2"); !ok || c.Len() != 1 {
+		t.Fatalf("k2 should never expire")
+	}
+	if c.Bytes() != int64(len("k2")+len("val2")) {
+		t.Fatalf("nBytes error, expected %d, got %d", len("k2")+len("val2"), c.Bytes())
+	}
+}
+
+func TestRemoveExpired(t *testing.T) {
+	reasons := make(map[string]lru.EvictReason)
+	callback := func(key string, value lru.Value, reason lru.EvictReason) {
+		reasons[key] = reason
+	}
+
+	c := lru.New(0, callback)
+	c.SetWithTTL("k1", String("val1"), 10*time.Millisecond)
+	c.SetWithTTL("k2", String("val2"), 10*time.Millisecond)
+	c.SetWithTTL("k3", String("val3"), time.Hour)
+	c.Set("k4", String("val4"))
+	c.Remove("k4")
+
+	time.Sleep(20 * time.Millisecond)
+
+	if n := c.RemoveExpired(); n != 2 {
+		t.Fatalf("RemoveExpired should remove 2 entries, but %d removed", n)
+	}
+	if c.Len() != 1 || c.Bytes() != int64(len("k3")+len("val3")) {
+		t.Fatalf("cache should only contain k3, len %d bytes %d", c.Len(), c.Bytes())
+	}
+
+	expect := map[string]lru.EvictReason{
+		"k1": lru.Expired,
+		"k2": lru.Expired,
+		"k4": lru.Removed,
+	}
+	if !reflect.DeepEqual(expect, reasons) {
+		t.Fatalf("Call OnEvicted failed, expect reasons %v, got %v", expect, reasons)
+	}
+}
+
+func TestUpdateBytes(t *testing.T) {
+	c := lru.New(0, nil)
+	c.Set("k1", String("val1"))
+	c.Set("k1", String("value1"))
+
+	if c.Bytes() != int64(len("k1")+len("value1")) {
+		t.Fatalf("nBytes error after update, expected %d, got %d", len("k1")+len("value1"), c.Bytes())
+	}
+}
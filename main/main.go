@@ -1,11 +1,17 @@
 package main
 
 import (
+	"context"
+	"errors"
 	"flag"
 	"fmt"
 	"log"
 	"net/http"
 	"skycache"
+	"skycache/discovery"
+	"skycache/gossip"
+	"strings"
+	"time"
 )
 
 var db = map[string]string{
@@ -14,11 +20,40 @@ var db = map[string]string{
 	"Sam":  "567",
 }
 
-func startCacheServer(addr string, addrs []string, gee *skycache.Group) {
-	peers := skycache.NewHTTPPool(addr)
-	peers.Set(addrs...)
+func startCacheServer(addr string, gee *skycache.Group) {
+	var opts []skycache.ServerOption
+	if admin {
+		// 管理接口可以修改集群的节点，只允许本机访问
+		opts = append(opts, skycache.WithAdmin("/_skycache/peers", nil))
+	}
+	peers := skycache.NewHTTPPool(addr, opts...)
+	peers.Set(addr)
 	gee.RegisterPeers(peers)
 
+	if gossipAddr != "" {
+		// 使用 gossip 时，节点列表由 gossip 维护
+		node, err := gossip.New(gossip.Config{
+			Name:     addr,
+			BindAddr: gossipAddr,
+			OnChange: func(members []string) { peers.Set(members...) },
+		})
+		if err != nil {
+			log.Fatal(err)
+		}
+		if seeds != "" {
+			if err := node.Join(strings.Split(seeds, ",")...); err != nil {
+				log.Println("failed to join:", err)
+			}
+		}
+	} else {
+		// 否则从 -peers 指定的来源获取，变化后自动生效
+		d, err := discovery.Parse(peerSource)
+		if err != nil {
+			log.Fatal(err)
+		}
+		go discovery.Watch(context.Background(), d, 5*time.Second, func(members []string) { peers.Set(members...) })
+	}
+
 	log.Println("skycache is running at", addr)
 	log.Fatal(http.ListenAndServe(addr[7:], peers))
 }
@@ -27,7 +62,11 @@ func startAPIServer(apiAddr string, gee *skycache.Group) {
 	http.Handle("/api", http.HandlerFunc(
 		func(w http.ResponseWriter, r *http.Request) {
 			key := r.URL.Query().Get("key")
-			view, err := gee.Get(key)
+			view, err := gee.Get(r.Context(), key)
+			if errors.Is(err, skycache.ErrNotFound) {
+				http.Error(w, err.Error(), http.StatusNotFound)
+				return
+			}
 			if err != nil {
 				http.Error(w, err.Error(), http.StatusInternalServerError)
 				return
@@ -41,25 +80,23 @@ func startAPIServer(apiAddr string, gee *skycache.Group) {
 
 }
 
+var gossipAddr, seeds, peerSource string
+var admin bool
+
 func main() {
 	var port int
 	var api bool
 	flag.IntVar(&port, "port", 8001, "skycache server port")
 	flag.BoolVar(&api, "api", false, "Start a api server?")
+	flag.BoolVar(&admin, "admin", false, "serve the peer admin endpoint at /_skycache/peers, loopback only")
+	flag.StringVar(&gossipAddr, "gossip", "", "UDP address for gossip membership, e.g. localhost:7946")
+	flag.StringVar(&seeds, "seeds", "", "comma separated gossip addresses of seed nodes")
+	flag.StringVar(&peerSource, "peers", "http://localhost:8001,http://localhost:8002,http://localhost:8003",
+		"peer discovery source: file:<path> (json, yaml or one address per line), dns:<SRV name>, or a comma separated list")
 	flag.Parse()
 
 	apiAddr := "http://localhost:9999"
-	// 三个节点
-	addrMap := map[int]string{
-		8001: "http://localhost:8001",
-		8002: "http://localhost:8002",
-		8003: "http://localhost:8003",
-	}
-
-	var addrs []string
-	for _, v := range addrMap {
-		addrs = append(addrs, v)
-	}
+	addr := fmt.Sprintf("http://localhost:%d", port)
 
 	scoregee := skycache.NewGroup("scores", 2<<10, skycache.GetterFunc(
 		func(key string) ([]byte, error) {
@@ -67,11 +104,11 @@ func main() {
 			if v, ok := db[key]; ok {
 				return []byte(v), nil
 			}
-			return nil, fmt.Errorf("%s not exist", key)
-		}))
+			return nil, fmt.Errorf("%s not exist: %w", key, skycache.ErrNotFound)
+		}), skycache.WithNegativeCache(10*time.Second, 1<<10))
 
 	if api {
 		go startAPIServer(apiAddr, scoregee)
 	}
-	startCacheServer(addrMap[port], []string(addrs), scoregee)
+	startCacheServer(addr, scoregee)
 }
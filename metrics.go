@@ -0,0 +1,169 @@
+package skycache
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// 使用 Prometheus 的文本格式导出统计信息，不依赖 prometheus 的库
+
+// 请求远程节点耗时的分桶上界，单位秒
+var defaultLatencyBuckets = []float64{.001, .0025, .005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5}
+
+// 累计直方图
+type histogram struct {
+	mu      sync.Mutex
+	buckets []float64 //分桶上界
+	counts  []uint64  //counts[i] 为落在 (buckets[i-1], buckets[i]] 的次数，最后一个为 +Inf
+	sum     float64
+	count   uint64
+}
+
+func newHistogram(buckets []float64) *histogram {
+	return &histogram{
+		buckets: buckets,
+		counts:  make([]uint64, len(buckets)+1),
+	}
+}
+
+func (h *histogram) observe(d time.Duration) {
+	v := d.Seconds()
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	i := sort.SearchFloat64s(h.buckets, v)
+	h.counts[i]++
+	h.sum += v
+	h.count++
+}
+
+// 写入 name 对应的 _bucket、_sum、_count 三组样本
+func (h *histogram) write(w io.Writer, name, labels string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	var cumulative uint64
+	for i, le := range h.buckets {
+		cumulative += h.counts[i]
+		fmt.Fprintf(w, "%s_bucket{%s,le=\"%g\"} %d\n", name, labels, le, cumulative)
+	}
+	cumulative += h.counts[len(h.buckets)]
+	fmt.Fprintf(w, "%s_bucket{%s,le=\"+Inf\"} %d\n", name, labels, cumulative)
+	fmt.Fprintf(w, "%s_sum{%s} %g\n", name, labels, h.sum)
+	fmt.Fprintf(w, "%s_count{%s} %d\n", name, labels, h.count)
+}
+
+func escapeLabel(v string) string {
+	return strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(v)
+}
+
+// 按名称排序的所有 group
+func sortedGroups() []*Group {
+	mu.RLock()
+	defer mu.RUnlock()
+
+	list := make([]*Group, 0, len(groups))
+	for _, g := range groups {
+		list = append(list, g)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].name < list[j].name })
+	return list
+}
+
+type groupMetric struct {
+	name, help, typ string
+	value           func(g *Group, s Stats) float64
+}
+
+var groupMetrics = []groupMetric{
+	{"skycache_gets_total", "Keys requested with Get.", "counter", func(g *Group, s Stats) float64 { return float64(s.Gets) }},
+	{"skycache_cache_hits_total", "Gets served by mainCache or hotCache.", "counter", func(g *Group, s Stats) float64 { return float64(s.CacheHits) }},
+	{"skycache_hit_ratio", "CacheHits divided by Gets.", "gauge", func(g *Group, s Stats) float64 {
+		if s.Gets == 0 {
+			return 0
+		}
+		return float64(s.CacheHits) / float64(s.Gets)
+	}},
+	{"skycache_negative_hits_total", "Gets answered as not found by the negative cache.", "counter", func(g *Group, s Stats) float64 { return float64(s.NegativeHits) }},
+	{"skycache_loads_total", "Gets that missed the cache.", "counter", func(g *Group, s Stats) float64 { return float64(s.Loads) }},
+	{"skycache_loads_deduped_total", "Loads left after singleflight deduplication.", "counter", func(g *Group, s Stats) float64 { return float64(s.LoadsDeduped) }},
+	{"skycache_loads_shared_total", "Loads whose result was shared by concurrent callers.", "counter", func(g *Group, s Stats) float64 { return float64(s.LoadsShared) }},
+	{"skycache_peer_loads_total", "Values fetched from peers.", "counter", func(g *Group, s Stats) float64 { return float64(s.PeerLoads) }},
+	{"skycache_peer_errors_total", "Failed requests to peers.", "counter", func(g *Group, s Stats) float64 { return float64(s.PeerErrors) }},
+	{"skycache_local_loads_total", "Values loaded by the Getter.", "counter", func(g *Group, s Stats) float64 { return float64(s.LocalLoads) }},
+	{"skycache_local_load_errors_total", "Failed calls to the Getter.", "counter", func(g *Group, s Stats) float64 { return float64(s.LocalLoadErrs) }},
+	{"skycache_bloom_rejects_total", "Loads rejected by the bloom filter.", "counter", func(g *Group, s Stats) float64 { return float64(s.BloomRejects) }},
+	{"skycache_lease_waits_total", "Loads served by another node holding the load lease.", "counter", func(g *Group, s Stats) float64 { return float64(s.LeaseWaits) }},
+	{"skycache_refreshes_total", "Background refreshes of entries nearing expiry.", "counter", func(g *Group, s Stats) float64 { return float64(s.Refreshes) }},
+	{"skycache_stale_hits_total", "Expired values served after a failed reload.", "counter", func(g *Group, s Stats) float64 { return float64(s.StaleHits) }},
+	{"skycache_server_requests_total", "Requests received from peers.", "counter", func(g *Group, s Stats) float64 { return float64(s.ServerRequests) }},
+	{"skycache_evictions_total", "Entries evicted for capacity.", "counter", func(g *Group, s Stats) float64 { return float64(s.Evictions) }},
+	{"skycache_expirations_total", "Entries removed after expiring.", "counter", func(g *Group, s Stats) float64 { return float64(s.Expirations) }},
+	{"skycache_capacity_bytes", "Configured cacheBytes of the group.", "gauge", func(g *Group, s Stats) float64 {
+		return float64(g.mainCache.cacheBytes + g.hotCache.cacheBytes)
+	}},
+	{"skycache_singleflight_inflight", "Loads currently in flight.", "gauge", func(g *Group, s Stats) float64 { return float64(g.loader.InFlight()) }},
+}
+
+// 导出所有 group 的统计信息，以及请求远程节点的耗时
+func (p *Server) serveMetrics(w http.ResponseWriter) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+
+	list := sortedGroups()
+	stats := make([]Stats, len(list))
+	for i, g := range list {
+		stats[i] = g.Stats()
+	}
+
+	for _, m := range groupMetrics {
+		fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", m.name, m.help, m.name, m.typ)
+		for i, g := range list {
+			fmt.Fprintf(w, "%s{group=\"%s\"} %g\n", m.name, escapeLabel(g.name), m.value(g, stats[i]))
+		}
+	}
+
+	// mainCache、hotCache 和 negCache 分别导出
+	caches := []struct {
+		label string
+		get   func(g *Group) CacheStats
+	}{
+		{"main", func(g *Group) CacheStats { return g.mainCache.stats() }},
+		{"hot", func(g *Group) CacheStats { return g.hotCache.stats() }},
+		{"negative", func(g *Group) CacheStats { return g.negCache.stats() }},
+	}
+	fmt.Fprintf(w, "# HELP skycache_cache_bytes Bytes used by the cache.\n# TYPE skycache_cache_bytes gauge\n")
+	for _, g := range list {
+		for _, c := range caches {
+			fmt.Fprintf(w, "skycache_cache_bytes{group=\"%s\",cache=\"%s\"} %d\n", escapeLabel(g.name), c.label, c.get(g).Bytes)
+		}
+	}
+	fmt.Fprintf(w, "# HELP skycache_cache_items Entries held by the cache.\n# TYPE skycache_cache_items gauge\n")
+	for _, g := range list {
+		for _, c := range caches {
+			fmt.Fprintf(w, "skycache_cache_items{group=\"%s\",cache=\"%s\"} %d\n", escapeLabel(g.name), c.label, c.get(g).Items)
+		}
+	}
+
+	p.mu.Lock()
+	peers := make([]string, 0, len(p.latencies))
+	for peer := range p.latencies {
+		peers = append(peers, peer)
+	}
+	sort.Strings(peers)
+	hists := make([]*histogram, len(peers))
+	for i, peer := range peers {
+		hists[i] = p.latencies[peer]
+	}
+	p.mu.Unlock()
+
+	const name = "skycache_peer_request_duration_seconds"
+	fmt.Fprintf(w, "# HELP %s Latency of Get requests sent to peers.\n# TYPE %s histogram\n", name, name)
+	for i, peer := range peers {
+		hists[i].write(w, name, fmt.Sprintf("peer=\"%s\"", escapeLabel(peer)))
+	}
+}
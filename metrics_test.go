@@ -0,0 +1,91 @@
+package skycache
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestMetrics(t *testing.T) {
+	getter := GetterFunc(func(key string) ([]byte, error) {
+		return []byte(db[key]), nil
+	})
+	g := NewGroup("metrics_scores", 2<<10, getter)
+
+	// 另一个节点使用独立的 group
+	remote := NewHTTPPool("")
+	other := newGroup("metrics_scores", 2<<10, getter)
+	remote.groups = func(string) *Group { return other }
+	rs := httptest.NewServer(remote)
+	defer rs.Close()
+
+	p := NewHTTPPool("", WithMetrics("/metrics"))
+	ts := httptest.NewServer(p)
+	defer ts.Close()
+	p.addr = ts.URL
+	p.Set(ts.URL, rs.URL)
+	g.RegisterPeers(p)
+
+	for k := range db {
+		g.Get(context.Background(), k)
+		g.Get(context.Background(), k)
+	}
+
+	resp, err := http.Get(ts.URL + "/metrics")
+	if err != nil || resp.StatusCode != http.StatusOK {
+		t.Fatalf("scrape /metrics failed: %v", err)
+	}
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(resp.Body)
+	text := string(body)
+
+	expects := []string{
+		"# TYPE skycache_gets_total counter",
+		`skycache_gets_total{group="metrics_scores"} 6`,
+		`skycache_hit_ratio{group="metrics_scores"}`,
+		`skycache_capacity_bytes{group="metrics_scores"} 2048`,
+		`skycache_cache_items{group="metrics_scores",cache="main"}`,
+		"# TYPE skycache_peer_request_duration_seconds histogram",
+		`skycache_peer_request_duration_seconds_bucket{peer="` + rs.URL + `",le="+Inf"}`,
+	}
+	for _, e := range expects {
+		if !strings.Contains(text, e) {
+			t.Fatalf("metrics should contain %q, got:\n%s", e, text)
+		}
+	}
+}
+
+func TestMetricsInFlight(t *testing.T) {
+	release := make(chan struct{})
+	g := NewGroup("metrics_inflight_scores", 2<<10, GetterFunc(func(key string) ([]byte, error) {
+		<-release
+		return []byte(db[key]), nil
+	}))
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		g.Get(context.Background(), "Tom")
+	}()
+	for g.loader.InFlight() == 0 {
+		time.Sleep(time.Millisecond)
+	}
+
+	// 正在进行的加载计入 gauge，结束后归零
+	scrape := func() string {
+		rec := httptest.NewRecorder()
+		NewHTTPPool("").serveMetrics(rec)
+		return rec.Body.String()
+	}
+	if text := scrape(); !strings.Contains(text, `skycache_singleflight_inflight{group="metrics_inflight_scores"} 1`) {
+		t.Fatalf("inflight gauge should be 1, got:\n%s", text)
+	}
+	close(release)
+	<-done
+	if text := scrape(); !strings.Contains(text, `skycache_singleflight_inflight{group="metrics_inflight_scores"} 0`) {
+		t.Fatalf("inflight gauge should drop to 0, got:\n%s", text)
+	}
+}
@@ -1,13 +1,69 @@
 package skycache
 
-import pb "skycache/skycachepb"
+import (
+	"context"
+	pb "skycache/skycachepb"
+)
 
 // 实现 根据 key,使用一致性哈希 选择相应的节点 的能力
 type PeerPicker interface {
 	PickPeer(key string) (peer PeerGetter, ok bool)
+	// 返回除自己以外的所有节点，用于广播失效消息
+	AllPeers() []PeerGetter
+}
+
+// 可选接口，PeerPicker 实现后 Group 可以使用 key 的多个所属节点
+type ReplicaPicker interface {
+	PeerPicker
+	// 返回 key 在哈希环上的前 n 个所属节点中，除自己以外的节点，按优先级排序
+	PickReplicas(key string, n int) []PeerGetter
+}
+
+// 可选接口，PeerPicker 实现后 Get 和 GetMulti 通过 PickReader 选择发送读请求的节点，
+// 可以按负载把读请求分给所属节点之外的节点；写入和删除仍然发给 PickPeer 返回的所属节点
+type ReadPicker interface {
+	PeerPicker
+	// 返回处理 key 的读请求的节点，返回 false 时由自己加载
+	PickReader(key string) (peer PeerGetter, ok bool)
 }
 
 // 实现 访问 group 和 key 获取对应的 value 的能力
+// ctx 的截止时间会传递给远程节点
 type PeerGetter interface {
-	Get(in *pb.Request, out *pb.Response) error
+	Get(ctx context.Context, in *pb.Request, out *pb.Response) error
+	// 一次获取多个 key，out 中只包含成功获取的 key
+	// 远程节点部分加载失败时返回 out.Error 对应的错误，out 中仍有成功的部分
+	GetMulti(ctx context.Context, in *pb.BatchRequest, out *pb.BatchResponse) error
+	// 在 key 的所属节点上写入，所属节点会广播失效消息
+	Set(ctx context.Context, in *pb.SetRequest) error
+	// 在 key 的所属节点上删除，所属节点会广播失效消息
+	Remove(ctx context.Context, in *pb.Request) error
+	// 只删除该节点本地的副本，不再继续广播
+	Invalidate(ctx context.Context, in *pb.Request) error
+}
+
+// 可选接口，PeerPicker 实现后 Group 可以使用集群级别的加载租约，见 WithLoadLease
+type LeasePicker interface {
+	PeerPicker
+	// 返回 key 的租约节点，即哈希环上所属节点之后第一个可用的节点，self 表示租约节点是自己
+	// 自己就是所属节点，或者没有可用的租约节点时 ok 为 false
+	PickLeaser(key string) (leaser PeerLeaser, self bool, ok bool)
+}
+
+// 可以发放加载租约的节点
+type PeerLeaser interface {
+	PeerGetter
+	// 申请或归还 key 的加载租约
+	Lease(ctx context.Context, in *pb.LeaseRequest, out *pb.LeaseResponse) error
+}
+
+// PeerGetter 可选实现的接口，发送请求前询问熔断器是否放行
+type peerGate interface {
+	allow() bool
+}
+
+// 向 PickReplicas 返回的节点发送请求前调用，没有熔断器的节点总是放行
+func allowPeer(peer PeerGetter) bool {
+	g, ok := peer.(peerGate)
+	return !ok || g.allow()
 }
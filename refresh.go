@@ -0,0 +1,98 @@
+package skycache
+
+import (
+	"context"
+	"errors"
+	"log"
+	"time"
+)
+
+// 记录存在的时间超过 ttl 的 fraction 后，Get 仍返回缓存的值，同时在后台重新加载
+// fraction 必须在 (0, 1) 之间，否则不会刷新或者每次命中都刷新；只对会过期的记录生效
+func WithRefreshAhead(fraction float64) GroupOption {
+	if !(fraction > 0 && fraction < 1) {
+		panic("refresh ahead fraction must be in (0, 1)")
+	}
+	return func(g *Group) {
+		g.refreshAhead = fraction
+	}
+}
+
+// mainCache 中的记录过期后继续保留 maxStale，重新加载失败时返回旧值
+// getter 确认 key 不存在(ErrNotFound)时不会返回旧值
+func WithStaleOnError(maxStale time.Duration) GroupOption {
+	return func(g *Group) {
+		g.mainCache.staleTTL = maxStale
+	}
+}
+
+// ctx 中携带的被刷新的记录
+type refreshKey struct{}
+
+// 加载是为了刷新 old，getLocally 只在 old 没有变化时写回 mainCache，并沿用它的 ttl
+func withRefresh(ctx context.Context, old entry) context.Context {
+	return context.WithValue(ctx, refreshKey{}, old)
+}
+
+// 把 getter 加载的结果写入 mainCache
+func (g *Group) populateLoaded(ctx context.Context, key string, value ByteView) {
+	if old, ok := ctx.Value(refreshKey{}).(entry); ok {
+		g.mainCache.replace(key, value, old)
+		return
+	}
+	g.polulateCache(key, value)
+}
+
+// 查询 mainCache，接近过期时在后台提前刷新
+// 记录已过期时 stale 为 true，只能在加载失败时使用
+func (g *Group) lookupMain(key string) (e entry, stale, ok bool) {
+	e, ok = g.mainCache.getEntry(key)
+	if !ok {
+		return entry{}, false, false
+	}
+	now := time.Now()
+	if e.expired(now) {
+		return e, true, true
+	}
+	if e.needsRefresh(now, g.refreshAhead) {
+		g.refresh(key, e)
+	}
+	return e, false, true
+}
+
+// 在后台重新加载 key，同一个 key 同时只有一个刷新
+// 刷新失败时保留原来的记录，直到它过期；所属节点是其他节点时，结果只会进入 hotCache
+func (g *Group) refresh(key string, old entry) {
+	if _, loading := g.refreshing.LoadOrStore(key, struct{}{}); loading {
+		return
+	}
+	g.stats.refreshes.Add(1)
+
+	go func() {
+		defer g.refreshing.Delete(key)
+		if _, err := g.doLoad(withRefresh(context.Background(), old), key); err != nil {
+			if errors.Is(err, ErrNotFound) {
+				g.mainCache.removeIf(key, old)
+			}
+			log.Printf("[Cache %s] failed to refresh %s: %v", g.name, key, err)
+		}
+	}()
+}
+
+// mainCache 中的记录已过期，重新加载，失败时返回旧值
+func (g *Group) loadStale(ctx context.Context, key string, old entry) (ByteView, error) {
+	value, err := g.load(withRefresh(ctx, old), key)
+	if err == nil {
+		// 从所属节点获取时旧值不会被替换，已经没有用了
+		g.mainCache.removeIf(key, old)
+		return value, nil
+	}
+	if errors.Is(err, ErrNotFound) {
+		g.mainCache.removeIf(key, old)
+		return ByteView{}, err
+	}
+
+	log.Printf("[Cache %s] serving stale %s: %v", g.name, key, err)
+	g.stats.staleHits.Add(1)
+	return old.value, nil
+}
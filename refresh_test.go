@@ -0,0 +1,152 @@
+package skycache
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestRefreshAhead(t *testing.T) {
+	var loads atomic.Int32
+	release := make(chan struct{})
+	g := newGroup("refresh_scores", 2<<10, GetterFunc(
+		func(key string) ([]byte, error) {
+			n := loads.Add(1)
+			if n > 1 {
+				<-release
+			}
+			return []byte(fmt.Sprintf("v%d", n)), nil
+		}), WithTTL(200*time.Millisecond), WithRefreshAhead(0.5))
+	ctx := context.Background()
+
+	if v, err := g.Get(ctx, "Tom"); err != nil || v.String() != "v1" {
+		t.Fatalf("first get should load v1, got %s, %v", v, err)
+	}
+
+	// 超过一半的 ttl 后，Get 立即返回旧值，后台只发起一次刷新
+	time.Sleep(110 * time.Millisecond)
+	for i := 0; i < 5; i++ {
+		if v, err := g.Get(ctx, "Tom"); err != nil || v.String() != "v1" {
+			t.Fatalf("get during refresh should return v1, got %s, %v", v, err)
+		}
+	}
+	close(release)
+
+	deadline := time.Now().Add(time.Second)
+	for {
+		if v, ok := g.mainCache.get("Tom"); ok && v.String() == "v2" {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("Tom was not refreshed")
+		}
+		time.Sleep(time.Millisecond)
+	}
+	stats := g.Stats()
+	if stats.Refreshes != 1 || loads.Load() != 2 {
+		t.Fatalf("expected 1 refresh and 2 getter calls, got %d and %d", stats.Refreshes, loads.Load())
+	}
+	if stats.Loads != 1 {
+		t.Fatalf("refresh should not count as a load, got %d", stats.Loads)
+	}
+
+	// fraction 不在 (0, 1) 之间时直接拒绝
+	for _, fraction := range []float64{-0.5, 0, 1, 1.5} {
+		func() {
+			defer func() {
+				if recover() == nil {
+					t.Fatalf("WithRefreshAhead should reject %v", fraction)
+				}
+			}()
+			WithRefreshAhead(fraction)
+		}()
+	}
+}
+
+func TestRefreshKeepsTTL(t *testing.T) {
+	var loads atomic.Int32
+	release := make(chan struct{})
+	g := newGroup("refresh_ttl_scores", 2<<10, GetterFunc(
+		func(key string) ([]byte, error) {
+			<-release
+			return []byte(fmt.Sprintf("v%d", loads.Add(1))), nil
+		}), WithRefreshAhead(0.5))
+	ctx := context.Background()
+	waitRefresh := func() {
+		deadline := time.Now().Add(time.Second)
+		for {
+			if _, ok := g.refreshing.Load("Tom"); !ok {
+				return
+			}
+			if time.Now().After(deadline) {
+				t.Fatal("refresh did not finish")
+			}
+			time.Sleep(time.Millisecond)
+		}
+	}
+
+	// 刷新沿用 SetWithTTL 的 ttl，而不是默认的永不过期
+	if err := g.SetWithTTL(ctx, "Tom", ByteView{b: []byte("set")}, 100*time.Millisecond); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(60 * time.Millisecond)
+	g.Get(ctx, "Tom")
+	release <- struct{}{}
+	waitRefresh()
+	e, _, ok := g.lookupMain("Tom")
+	if !ok || e.value.String() != "v1" || e.expire.Sub(e.created) != 100*time.Millisecond {
+		t.Fatalf("refresh should keep the 100ms ttl, got %s, %v", e.value, e.expire.Sub(e.created))
+	}
+
+	// 刷新期间写入的新值不会被刷新的结果覆盖
+	time.Sleep(60 * time.Millisecond)
+	g.Get(ctx, "Tom")
+	if err := g.SetWithTTL(ctx, "Tom", ByteView{b: []byte("newer")}, time.Minute); err != nil {
+		t.Fatal(err)
+	}
+	release <- struct{}{}
+	waitRefresh()
+	if v, ok := g.mainCache.get("Tom"); !ok || v.String() != "newer" {
+		t.Fatalf("refresh should not overwrite a newer value, got %s", v)
+	}
+}
+
+func TestStaleOnError(t *testing.T) {
+	var failure atomic.Pointer[error]
+	g := newGroup("stale_scores", 2<<10, GetterFunc(
+		func(key string) ([]byte, error) {
+			if err := failure.Load(); err != nil {
+				return nil, *err
+			}
+			return []byte(db[key]), nil
+		}), WithTTL(50*time.Millisecond), WithStaleOnError(time.Minute))
+	ctx := context.Background()
+
+	if _, err := g.Get(ctx, "Tom"); err != nil {
+		t.Fatalf("get Tom failed: %v", err)
+	}
+
+	// 过期后 getter 失败，返回旧值
+	down := errors.New("db is down")
+	failure.Store(&down)
+	time.Sleep(60 * time.Millisecond)
+	if v, err := g.Get(ctx, "Tom"); err != nil || v.String() != "630" {
+		t.Fatalf("expected stale 630, got %s, %v", v, err)
+	}
+	if stats := g.Stats(); stats.StaleHits != 1 || stats.CacheHits != 0 {
+		t.Fatalf("expected 1 stale hit and no cache hit, got %+v", stats)
+	}
+
+	// getter 确认不存在时不返回旧值
+	notFound := fmt.Errorf("Tom: %w", ErrNotFound)
+	failure.Store(&notFound)
+	if _, err := g.Get(ctx, "Tom"); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("expected ErrNotFound, got %v", err)
+	}
+	if _, _, ok := g.lookupMain("Tom"); ok {
+		t.Fatal("stale Tom should be removed after ErrNotFound")
+	}
+}
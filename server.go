@@ -1,11 +1,16 @@
 package skycache
 
 import (
+	"context"
+	"errors"
 	"fmt"
+	"io"
 	"log"
 	"net/http"
+	"sort"
 	"strings"
 	"sync"
+	"time"
 
 	"skycache/consistenthash"
 	pb "skycache/skycachepb"
@@ -22,22 +27,125 @@ const (
 )
 
 // 确保 实现了对应的接口
-var _ PeerPicker = (*Server)(nil)
+var (
+	_ ReplicaPicker = (*Server)(nil)
+	_ LeasePicker   = (*Server)(nil)
+)
 
 // 和Group 解耦合，实现
 type Server struct {
-	addr        string     // ip:port 的形式
-	basePath    string     //节点通信的 前缀URL
-	mu          sync.Mutex // guards peers and httpGetters
-	peers       *consistenthash.HashMap
-	httpGetters map[string]*Client // keyed by e.g. "http://10.0.0.2:8008"
+	addr         string                   // ip:port 的形式
+	basePath     string                   //节点通信的 前缀URL
+	mu           sync.Mutex               // guards peers and httpGetters
+	peers        consistenthash.Placement // 为 nil 时使用 defaultReplicas 个虚拟节点的哈希环
+	httpGetters  map[string]*Client       // keyed by e.g. "http://10.0.0.2:8008"
+	groups       func(name string) *Group
+	metricsPath  string                // 为空则不导出 metrics
+	latencies    map[string]*histogram // 请求各个节点的耗时
+	adminPath    string                // 为空则不提供管理接口
+	adminAuth    func(*http.Request) bool
+	drainTimeout time.Duration // 移除节点时，等待正在进行的请求的最长时间
+
+	breakers         map[string]*breaker // 各个节点的熔断器
+	breakerThreshold int
+	breakerCooldown  time.Duration
+	healthInterval   time.Duration // 主动健康检查的间隔，为 0 则不检查
+	done             chan struct{}
+	closeOnce        sync.Once
+}
+
+const defaultDrainTimeout = 5 * time.Second
+
+// NewHTTPPool 的可选配置
+type ServerOption func(*Server)
+
+// 在 path 上导出 Prometheus 格式的 metrics，例如 "/metrics"
+func WithMetrics(path string) ServerOption {
+	return func(p *Server) {
+		p.metricsPath = path
+	}
+}
+
+// 在 path 上提供管理节点的接口，例如 "/_skycache/peers"，详见 serveAdmin
+// authorize 返回 false 的请求被拒绝，为 nil 时只允许来自本机回环地址的请求
+func WithAdmin(path string, authorize func(r *http.Request) bool) ServerOption {
+	return func(p *Server) {
+		p.adminPath = path
+		p.adminAuth = authorize
+	}
+}
+
+// 设置移除节点时等待正在进行的请求的最长时间，默认为 5s
+func WithDrainTimeout(timeout time.Duration) ServerOption {
+	return func(p *Server) {
+		p.drainTimeout = timeout
+	}
+}
+
+// 设置熔断器：连续失败 threshold 次后跳过该节点，cooldown 后再尝试
+// 默认为 5 次和 10s，threshold 为 0 则不熔断
+func WithCircuitBreaker(threshold int, cooldown time.Duration) ServerOption {
+	return func(p *Server) {
+		p.breakerThreshold = threshold
+		p.breakerCooldown = cooldown
+	}
+}
+
+// 每隔 interval 访问其他节点的 /healthz，结果同样计入熔断器
+func WithHealthCheck(interval time.Duration) ServerOption {
+	return func(p *Server) {
+		p.healthInterval = interval
+	}
+}
+
+// 结果随负载变化的 Placement，例如 consistenthash.Bounded
+// Ring 决定 key 的所属节点，Get 只用于分配读请求，负载由 Client 通过 Inc/Done 上报
+type loadBalancer interface {
+	consistenthash.Placement
+	loadReporter
+	Ring() consistenthash.Placement
 }
 
-func NewHTTPPool(self string) *Server {
-	return &Server{
-		addr:     self,
-		basePath: defaultBasePath,
+// 决定所属节点的 Placement，调用前需要持有 mu
+func (p *Server) owners() consistenthash.Placement {
+	if lb, ok := p.peers.(loadBalancer); ok {
+		return lb.Ring()
 	}
+	return p.peers
+}
+
+// 使用其他的节点选择算法，例如 consistenthash.NewMaglev(0, nil)，默认为哈希环
+// 每个 Server 需要单独的实例，所有节点应使用相同的算法
+//
+// 使用 consistenthash.Bounded 时，所属节点由它的 Ring 决定，Set、失效广播、副本和租约不受负载影响；
+// 只有 Get 和 GetMulti 按负载分给其他节点(见 PickReader)，负载是发往每个节点的正在进行的请求数
+func WithPlacement(placement consistenthash.Placement) ServerOption {
+	return func(p *Server) {
+		p.peers = placement
+	}
+}
+
+func NewHTTPPool(self string, opts ...ServerOption) *Server {
+	p := &Server{
+		addr:             self,
+		basePath:         defaultBasePath,
+		drainTimeout:     defaultDrainTimeout,
+		breakerThreshold: defaultBreakerThreshold,
+		breakerCooldown:  defaultBreakerCooldown,
+		done:             make(chan struct{}),
+	}
+	for _, opt := range opts {
+		opt(p)
+	}
+	if p.healthInterval > 0 {
+		go p.healthCheck(p.healthInterval)
+	}
+	return p
+}
+
+// 停止后台的健康检查
+func (p *Server) Close() {
+	p.closeOnce.Do(func() { close(p.done) })
 }
 
 func (p *Server) Log(format string, v ...interface{}) {
@@ -45,8 +153,20 @@ func (p *Server) Log(format string, v ...interface{}) {
 }
 
 // 实现节点间的通信，在自己的 groups 下查找对应的 group 和 key
-// 返回 err(如果未找到) 或 value
+// GET 返回 err(如果未找到) 或 value，PUT 写入，DELETE 删除
 func (p *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path == healthPath {
+		w.Write([]byte("ok"))
+		return
+	}
+	if p.metricsPath != "" && r.URL.Path == p.metricsPath {
+		p.serveMetrics(w)
+		return
+	}
+	if p.adminPath != "" && r.URL.Path == p.adminPath {
+		p.serveAdmin(w, r)
+		return
+	}
 	if !strings.HasPrefix(r.URL.Path, p.basePath) {
 		// ? should i panic in this case?
 		log.Println(r.URL.Path, p.basePath)
@@ -54,9 +174,16 @@ func (p *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 	p.Log("%s %s", r.Method, r.URL.Path)
+	ctx, cancel := requestContext(r)
+	defer cancel()
 
 	//should be url like <basePath>/<groupname>/<key>
+	//批量获取时为 POST <basePath>/<groupname>
 	parts := strings.SplitN(r.URL.Path[len(p.basePath):], "/", 2)
+	if len(parts) == 1 && r.Method == http.MethodPost {
+		p.serveGetMulti(ctx, w, r, parts[0])
+		return
+	}
 	if len(parts) != 2 {
 		http.Error(w, "bad request", http.StatusBadRequest)
 		return
@@ -64,20 +191,57 @@ func (p *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 
 	groupname, key := parts[0], parts[1]
 
-	group := groups[groupname]
+	group := p.getGroup(groupname)
 	if group == nil {
 		http.Error(w, "no such group", http.StatusInternalServerError)
 		return
 	}
 
-	view, err := group.Get(key)
-	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+	group.stats.serverRequests.Add(1)
+	switch r.Method {
+	case http.MethodGet:
+		p.serveGet(ctx, w, group, key)
+	case http.MethodPut:
+		p.serveSet(ctx, w, r, group, key)
+	case http.MethodPost:
+		p.serveLease(w, r, group, key)
+	case http.MethodDelete:
+		// 来自所属节点的失效广播，只删除本地副本
+		if r.URL.Query().Get("invalidate") != "" {
+			group.invalidate(key)
+			return
+		}
+		if err := group.removeAsOwner(ctx, key); err != nil {
+			http.Error(w, err.Error(), httpStatus(err))
+		}
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// 请求的 context，带上调用方通过 timeoutHeader 传来的超时时间
+func requestContext(r *http.Request) (context.Context, context.CancelFunc) {
+	if v := r.Header.Get(timeoutHeader); v != "" {
+		if timeout, err := time.ParseDuration(v); err == nil {
+			return context.WithTimeout(r.Context(), timeout)
+		}
+	}
+	return context.WithCancel(r.Context())
+}
+
+func (p *Server) serveGet(ctx context.Context, w http.ResponseWriter, group *Group, key string) {
+	view, err := group.Get(withPeerRequest(ctx), key)
+	resp := &pb.Response{Value: view.ByteSlice()}
+	if errors.Is(err, ErrNotFound) {
+		// 不存在也是一种结果，调用方可以据此缓存
+		resp.NotFound = true
+	} else if err != nil {
+		http.Error(w, err.Error(), httpStatus(err))
 		return
 	}
 
 	//改为使用 protobuf 传输数据
-	body, err := proto.Marshal(&pb.Response{Value: view.ByteSlice()})
+	body, err := proto.Marshal(resp)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
@@ -88,25 +252,347 @@ func (p *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	w.Write(body)
 }
 
+func (p *Server) serveGetMulti(ctx context.Context, w http.ResponseWriter, r *http.Request, groupname string) {
+	group := p.getGroup(groupname)
+	if group == nil {
+		http.Error(w, "no such group", http.StatusInternalServerError)
+		return
+	}
+
+	group.stats.serverRequests.Add(1)
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	in := &pb.BatchRequest{}
+	if err = proto.Unmarshal(body, in); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	views, err := group.GetMulti(withPeerRequest(ctx), in.Keys)
+	body, err = proto.Marshal(batchResponse(views, err))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/octet-stream")
+	w.Write(body)
+}
+
+func (p *Server) serveSet(ctx context.Context, w http.ResponseWriter, r *http.Request, group *Group, key string) {
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	in := &pb.SetRequest{}
+	if err = proto.Unmarshal(body, in); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if in.Replica {
+		group.setAsReplica(key, ByteView{b: in.Value}, time.Duration(in.Ttl))
+		group.leases.done(key, in.Lease)
+		return
+	}
+	if err = group.setAsOwner(ctx, key, ByteView{b: in.Value}, time.Duration(in.Ttl)); err != nil {
+		http.Error(w, err.Error(), httpStatus(err))
+	}
+}
+
+// POST <basePath>/<group>/<key>，申请或归还 key 的加载租约
+func (p *Server) serveLease(w http.ResponseWriter, r *http.Request, group *Group, key string) {
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	in := &pb.LeaseRequest{}
+	if err = proto.Unmarshal(body, in); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	in.Key = key
+
+	body, err = proto.Marshal(group.grantLease(in))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/octet-stream")
+	w.Write(body)
+}
+
+// 查找 group，测试中可以替换，模拟多个进程
+func (p *Server) getGroup(name string) *Group {
+	if p.groups != nil {
+		return p.groups(name)
+	}
+	return GetGroup(name)
+}
+
 // Set updates the pool's list of peers.
+// 只增删有变化的节点，被移除节点上正在进行的请求会先完成
 func (p *Server) Set(peers ...string) {
+	want := make(map[string]bool, len(peers))
+	for _, peer := range peers {
+		want[peer] = true
+	}
+
+	var removed []string
+	p.mu.Lock()
+	for peer := range p.httpGetters {
+		if !want[peer] {
+			removed = append(removed, peer)
+		}
+	}
+	p.mu.Unlock()
+
+	p.AddPeers(peers...)
+	p.RemovePeers(removed...)
+}
+
+// 和 Set 相同，但每个节点带有权重(例如按内存大小)，分到的 key 大致与权重成正比
+// 已存在节点的权重变化时只调整哈希环，不会重建连接；Placement 不支持权重时忽略权重
+// 权重 <= 0 的节点和不在列表中的节点一样会被移除
+func (p *Server) SetWeighted(peers map[string]int) {
+	var removed []string
+	p.mu.Lock()
+	p.init(len(peers))
+	for peer := range p.httpGetters {
+		if weight, ok := peers[peer]; !ok || weight <= 0 {
+			removed = append(removed, peer)
+		}
+	}
+	weighted, ok := p.peers.(consistenthash.Weighted)
+	if !ok {
+		p.Log("placement %T does not support weights, ignoring them", p.peers)
+	}
+	for peer, weight := range peers {
+		if weight <= 0 {
+			continue
+		}
+		if _, ok := p.httpGetters[peer]; !ok {
+			p.addPeer(peer)
+			if weighted == nil {
+				p.peers.Add(peer)
+			}
+		}
+		if weighted != nil && weighted.Weight(peer) != weight {
+			weighted.AddWeighted(peer, weight)
+		}
+	}
+	p.mu.Unlock()
+
+	p.RemovePeers(removed...)
+}
+
+// 增加节点，权重为 1，已存在的节点会被忽略
+func (p *Server) AddPeers(peers ...string) {
 	p.mu.Lock()
 	defer p.mu.Unlock()
-	p.peers = consistenthash.New(defaultReplicas, nil)
-	p.peers.Add(peers...)
-	p.httpGetters = make(map[string]*Client, len(peers))
+	p.init(len(peers))
+	for _, peer := range peers {
+		if _, ok := p.httpGetters[peer]; ok {
+			continue
+		}
+		p.addPeer(peer)
+		p.peers.Add(peer)
+	}
+}
+
+func (p *Server) init(n int) {
+	if p.peers == nil {
+		p.peers = consistenthash.New(defaultReplicas, nil)
+	}
+	if p.httpGetters == nil {
+		p.httpGetters = make(map[string]*Client, n)
+	}
+	if p.breakers == nil {
+		p.breakers = make(map[string]*breaker, n)
+	}
+}
+
+// 创建节点的 client 和熔断器，调用方负责加入哈希环
+func (p *Server) addPeer(peer string) {
+	c := newClient(peer+p.basePath, p.latency(peer))
+	c.health = newBreaker(peer, p.breakerThreshold, p.breakerCooldown)
+	if lb, ok := p.peers.(loadBalancer); ok {
+		c.peer, c.loads = peer, lb
+	}
+	p.httpGetters[peer] = c
+	p.breakers[peer] = c.health
+}
+
+// 移除节点，不存在的节点会被忽略
+// 返回前会等待发往被移除节点的请求完成，最多等待 drainTimeout
+func (p *Server) RemovePeers(peers ...string) {
+	var clients []*Client
+	p.mu.Lock()
 	for _, peer := range peers {
-		p.httpGetters[peer] = &Client{target: peer + p.basePath}
+		c, ok := p.httpGetters[peer]
+		if !ok {
+			continue
+		}
+		p.peers.Remove(peer)
+		delete(p.httpGetters, peer)
+		delete(p.latencies, peer)
+		delete(p.breakers, peer)
+		clients = append(clients, c)
+	}
+	p.mu.Unlock()
+
+	var wg sync.WaitGroup
+	for _, c := range clients {
+		wg.Add(1)
+		go func(c *Client) {
+			defer wg.Done()
+			if !c.drain(p.drainTimeout) {
+				p.Log("requests to %s did not finish in %v", c.target, p.drainTimeout)
+			}
+		}(c)
+	}
+	wg.Wait()
+}
+
+// 返回当前所有节点(包括自己)，按地址排序
+func (p *Server) Peers() []string {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	peers := make([]string, 0, len(p.httpGetters))
+	for peer := range p.httpGetters {
+		peers = append(peers, peer)
+	}
+	sort.Strings(peers)
+	return peers
+}
+
+// 请求 peer 的耗时直方图，未开启 metrics 时为 nil
+func (p *Server) latency(peer string) *histogram {
+	if p.metricsPath == "" || peer == p.addr {
+		return nil
+	}
+	if p.latencies == nil {
+		p.latencies = make(map[string]*histogram)
+	}
+	h, ok := p.latencies[peer]
+	if !ok {
+		h = newHistogram(defaultLatencyBuckets)
+		p.latencies[peer] = h
 	}
+	return h
 }
 
 // PickPeer picks a peer according to key
+// 所属节点熔断时，选择哈希环上的下一个节点；轮到自己时返回 false，由自己加载
 func (p *Server) PickPeer(key string) (PeerGetter, bool) {
 	p.mu.Lock()
 	defer p.mu.Unlock()
-	if peer := p.peers.Get(key); peer != "" && peer != p.addr {
-		p.Log("Pick peer %s", peer)
-		return p.httpGetters[peer], true
+	if len(p.httpGetters) == 0 {
+		return nil, false
+	}
+
+	picked := ""
+	p.owners().Walk(key, func(peer string) bool {
+		if peer == p.addr {
+			return false
+		}
+		if c := p.httpGetters[peer]; c.health != nil && !c.health.allow() {
+			p.Log("Skip unhealthy peer %s", peer)
+			return true
+		}
+		picked = peer
+		return false
+	})
+	if picked == "" {
+		return nil, false
+	}
+	p.Log("Pick peer %s", picked)
+	return p.httpGetters[picked], true
+}
+
+// 按负载选择读请求的节点，只有 Placement 是 consistenthash.Bounded 时才会选出所属节点以外的节点
+// 选中自己或者熔断的节点时交给 PickPeer，自己不是所属节点时不能自己加载
+func (p *Server) PickReader(key string) (PeerGetter, bool) {
+	p.mu.Lock()
+	lb, ok := p.peers.(loadBalancer)
+	var c *Client
+	if ok {
+		peer, _ := lb.Get(key)
+		if peer != p.addr {
+			c = p.httpGetters[peer]
+		}
+	}
+	p.mu.Unlock()
+
+	if c == nil || !c.allow() {
+		return p.PickPeer(key)
+	}
+	p.Log("Pick reader %s", c.peer)
+	return c, true
+}
+
+// 返回 key 的前 n 个所属节点中除自己以外的节点，熔断的节点会被跳过
+// 这里不占用熔断器的探测名额，调用方发送请求前通过 allowPeer 申请
+func (p *Server) PickReplicas(key string, n int) []PeerGetter {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if len(p.httpGetters) == 0 {
+		return nil
+	}
+
+	var replicas []PeerGetter
+	for _, peer := range consistenthash.GetN(p.owners(), key, n) {
+		c := p.httpGetters[peer]
+		if peer == p.addr || (c.health != nil && !c.health.available()) {
+			continue
+		}
+		replicas = append(replicas, c)
+	}
+	return replicas
+}
+
+// 租约节点是哈希环上所属节点之后第一个可用的节点
+func (p *Server) PickLeaser(key string) (PeerLeaser, bool, bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if len(p.httpGetters) == 0 {
+		return nil, false, false
+	}
+
+	leaser, owner := "", true
+	p.owners().Walk(key, func(peer string) bool {
+		if owner {
+			owner = false
+			return peer != p.addr //自己就是所属节点，不需要租约
+		}
+		if c := p.httpGetters[peer]; peer == p.addr || c.health == nil || c.health.allow() {
+			leaser = peer
+			return false
+		}
+		return true
+	})
+	switch leaser {
+	case "":
+		return nil, false, false
+	case p.addr:
+		return nil, true, true
+	}
+	return p.httpGetters[leaser], false, true
+}
+
+// AllPeers returns all peers except self
+func (p *Server) AllPeers() []PeerGetter {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	peers := make([]PeerGetter, 0, len(p.httpGetters))
+	for peer, getter := range p.httpGetters {
+		if peer != p.addr {
+			peers = append(peers, getter)
+		}
 	}
-	return nil, false
+	return peers
 }
@@ -1,6 +1,12 @@
 package singleflight
 
-import "sync"
+import (
+	"context"
+	"errors"
+	"fmt"
+	"runtime/debug"
+	"sync"
+)
 
 // 使用 sync.WaitGroup 来防止缓存穿透
 
@@ -9,14 +15,54 @@ type call struct {
 	wg    sync.WaitGroup
 	value interface{}
 	err   error
+
+	// 以下字段由 Group.mu 保护
+	dups  int             //共享这次请求结果的其他调用方数量
+	chans []chan<- Result //DoChan 的调用方
+}
+
+// fn 调用了 runtime.Goexit 时，等待的调用方得到的错误
+var ErrGoexit = errors.New("singleflight: runtime.Goexit was called")
+
+// fn panic 时，等待的调用方得到的错误，包含 panic 的值和 fn 中的堆栈
+// 执行 fn 的 Do 调用方会以这个值重新 panic
+type PanicError struct {
+	Value interface{}
+	Stack []byte
+}
+
+func newPanicError(v interface{}) *PanicError {
+	return &PanicError{Value: v, Stack: debug.Stack()}
 }
 
+// 不包含堆栈，错误可能被返回给其他节点
+func (p *PanicError) Error() string {
+	return fmt.Sprintf("singleflight: fn panicked: %v", p.Value)
+}
+
+func (p *PanicError) Unwrap() error {
+	err, _ := p.Value.(error)
+	return err
+}
+
+// DoChan 返回的结果
+type Result struct {
+	Val    interface{}
+	Err    error
+	Shared bool //结果是否同时返回给了多个调用方
+}
+
+// 合并对相同 key 的并发请求，零值可以直接使用，使用后不能复制
 type Group struct {
 	mu sync.Mutex //包含m
 	m  map[string]*call
 }
 
-func (g Group) Do(key string, fn func() (interface{}, error)) (interface{}, error) {
+// 执行 fn 并返回结果，同一时刻对同一个 key 只执行一次，其他调用方等待并共享结果
+// shared 表示结果是否同时返回给了多个调用方。
+// fn panic 时，执行 fn 的调用方以 PanicError 重新 panic，其他调用方得到 PanicError 错误；
+// fn 调用 runtime.Goexit 时，其他调用方得到 ErrGoexit
+func (g *Group) Do(key string, fn func() (interface{}, error)) (v interface{}, err error, shared bool) {
 	g.mu.Lock()
 
 	if g.m == nil {
@@ -24,10 +70,11 @@ func (g Group) Do(key string, fn func() (interface{}, error)) (interface{}, erro
 	}
 	// 当前时刻，已经发起了对 key 的请求
 	if c, ok := g.m[key]; ok {
+		c.dups++
 		g.mu.Unlock()
 		c.wg.Wait() //任意时刻，wg 最多被 add 1
 		//到这里，即请求完毕
-		return c.value, c.err
+		return c.value, c.err, true
 	}
 
 	// 第一次发起请求
@@ -36,13 +83,108 @@ func (g Group) Do(key string, fn func() (interface{}, error)) (interface{}, erro
 	g.m[key] = c
 	g.mu.Unlock()
 
-	c.value, c.err = fn()
-	c.wg.Done()
+	// 在调用方自己的 goroutine 中执行 fn，panic 时把它还给调用方
+	if perr := g.doCall(c, key, fn); perr != nil {
+		panic(perr)
+	}
+	return c.value, c.err, c.dups > 0
+}
+
+// 和 Do 一样，但不阻塞，结果在准备好后发送到返回的 channel 中，便于配合 select 设置超时
+// channel 有缓冲，调用方不读取也不会阻塞 fn。
+// fn 在单独的 goroutine 中执行，panic 时只作为 PanicError 返回，不会使进程崩溃
+func (g *Group) DoChan(key string, fn func() (interface{}, error)) <-chan Result {
+	ch := make(chan Result, 1)
+	g.mu.Lock()
+
+	if g.m == nil {
+		g.m = make(map[string]*call)
+	}
+	if c, ok := g.m[key]; ok {
+		c.dups++
+		c.chans = append(c.chans, ch)
+		g.mu.Unlock()
+		return ch
+	}
+
+	c := &call{chans: []chan<- Result{ch}}
+	c.wg.Add(1)
+	g.m[key] = c
+	g.mu.Unlock()
+
+	go func() {
+		_ = g.doCall(c, key, fn)
+	}()
+	return ch
+}
+
+// 和 Do 一样合并相同 key 的请求，但 fn 在单独的 goroutine 中执行，
+// 任何一个调用方都可以在 ctx 结束时放弃等待，而不会取消共享的 fn
+func (g *Group) DoContext(ctx context.Context, key string, fn func() (interface{}, error)) (v interface{}, err error, shared bool) {
+	select {
+	case r := <-g.DoChan(key, fn):
+		return r.Val, r.Err, r.Shared
+	case <-ctx.Done():
+		return nil, ctx.Err(), false
+	}
+}
 
-	// 请求完毕，更新 g.m
+// 执行 fn，然后把结果交给所有等待的调用方
+// fn panic 或调用 runtime.Goexit 时，等待的调用方也会得到错误，不会永远阻塞。
+// fn panic 时返回 PanicError，由调用方决定是否重新 panic；fn 调用 runtime.Goexit 时不会返回
+func (g *Group) doCall(c *call, key string, fn func() (interface{}, error)) (perr *PanicError) {
+	normalReturn := false
+	recovered := false
+
+	defer func() {
+		// 既没有正常返回也没有 panic，只能是 runtime.Goexit
+		if !normalReturn && !recovered {
+			c.err = ErrGoexit
+		}
+
+		// 请求完毕，更新 g.m
+		g.mu.Lock()
+		c.wg.Done()
+		if g.m[key] == c {
+			delete(g.m, key)
+		}
+		for _, ch := range c.chans {
+			ch <- Result{Val: c.value, Err: c.err, Shared: c.dups > 0}
+		}
+		g.mu.Unlock()
+	}()
+
+	func() {
+		defer func() {
+			if !normalReturn {
+				// recover 返回 nil 时是 runtime.Goexit，继续退出
+				if r := recover(); r != nil {
+					perr = newPanicError(r)
+					c.err = perr
+				}
+			}
+		}()
+		c.value, c.err = fn()
+		normalReturn = true
+	}()
+
+	if !normalReturn {
+		recovered = true
+	}
+	return perr
+}
+
+// 忘记正在进行的对 key 的请求，之后的调用会重新执行 fn，而不是等待这次的结果
+// 已经在等待的调用方不受影响，例如 key 的值已被修改，不希望再返回旧的结果
+func (g *Group) Forget(key string) {
 	g.mu.Lock()
 	delete(g.m, key)
 	g.mu.Unlock()
+}
 
-	return c.value, c.err
+// 当前正在进行中的请求数量
+func (g *Group) InFlight() int {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	return len(g.m)
 }
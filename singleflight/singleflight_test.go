@@ -0,0 +1,363 @@
+package singleflight
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"runtime"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestDo(t *testing.T) {
+	var g Group
+	v, err, shared := g.Do("key", func() (interface{}, error) {
+		return "bar", nil
+	})
+	if v != "bar" || err != nil || shared {
+		t.Fatalf("Do = %v, %v, %v", v, err, shared)
+	}
+}
+
+// 零值的 Group 也要真正合并请求，所有调用方都拿到共享的结果
+func TestDoDedup(t *testing.T) {
+	var g Group
+	var calls atomic.Int32
+	release := make(chan struct{})
+
+	const n = 10
+	var wg sync.WaitGroup
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			v, err, shared := g.Do("key", func() (interface{}, error) {
+				calls.Add(1)
+				<-release
+				return "bar", nil
+			})
+			if v != "bar" || err != nil || !shared {
+				t.Errorf("Do = %v, %v, %v", v, err, shared)
+			}
+		}()
+	}
+	// 等所有调用方都进入等待
+	for {
+		g.mu.Lock()
+		c := g.m["key"]
+		ready := c != nil && c.dups == n-1
+		g.mu.Unlock()
+		if ready {
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+	close(release)
+	wg.Wait()
+
+	if got := calls.Load(); got != 1 {
+		t.Fatalf("fn called %d times", got)
+	}
+	if g.InFlight() != 0 {
+		t.Fatal("finished call should be removed")
+	}
+}
+
+// 大量 goroutine 并发请求少量 key，每个 key 同一时刻最多只有一个 fn 在执行
+func TestDoConcurrent(t *testing.T) {
+	var g Group
+	var running [4]atomic.Int32
+	var calls, shared atomic.Int32
+
+	var wg sync.WaitGroup
+	for i := 0; i < 200; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			k := i % len(running)
+			v, err, s := g.Do(fmt.Sprint("key", k), func() (interface{}, error) {
+				calls.Add(1)
+				if running[k].Add(1) != 1 {
+					t.Errorf("key%d loaded concurrently", k)
+				}
+				time.Sleep(time.Millisecond)
+				running[k].Add(-1)
+				return k, nil
+			})
+			if v != k || err != nil {
+				t.Errorf("Do = %v, %v", v, err)
+			}
+			if s {
+				shared.Add(1)
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	if calls.Load() >= 200 || shared.Load() == 0 {
+		t.Fatalf("no deduplication: %d calls, %d shared", calls.Load(), shared.Load())
+	}
+}
+
+func TestDoChan(t *testing.T) {
+	var g Group
+	release := make(chan struct{})
+	fn := func() (interface{}, error) {
+		<-release
+		return "bar", nil
+	}
+
+	ch1 := g.DoChan("key", fn)
+	ch2 := g.DoChan("key", func() (interface{}, error) {
+		t.Error("second fn should not run")
+		return nil, nil
+	})
+
+	// 配合 select 实现超时
+	select {
+	case <-ch1:
+		t.Fatal("result should not be ready")
+	case <-time.After(10 * time.Millisecond):
+	}
+
+	close(release)
+	for _, ch := range []<-chan Result{ch1, ch2} {
+		r := <-ch
+		if r.Val != "bar" || r.Err != nil || !r.Shared {
+			t.Fatalf("unexpected result %+v", r)
+		}
+	}
+}
+
+func TestForget(t *testing.T) {
+	var g Group
+	release := make(chan struct{})
+	first := g.DoChan("key", func() (interface{}, error) {
+		<-release
+		return "old", nil
+	})
+
+	// Forget 之后的调用重新执行 fn
+	g.Forget("key")
+	v, _, shared := g.Do("key", func() (interface{}, error) {
+		return "new", nil
+	})
+	if v != "new" || shared {
+		t.Fatalf("Do after Forget = %v, %v", v, shared)
+	}
+
+	close(release)
+	if r := <-first; r.Val != "old" {
+		t.Fatalf("forgotten call returned %v", r.Val)
+	}
+	if g.InFlight() != 0 {
+		t.Fatal("no call should be in flight")
+	}
+}
+
+func TestDoContextDedup(t *testing.T) {
+	var g Group
+	var calls atomic.Int32
+	release := make(chan struct{})
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			v, err, _ := g.DoContext(context.Background(), "key", func() (interface{}, error) {
+				calls.Add(1)
+				<-release
+				return "bar", nil
+			})
+			if v != "bar" || err != nil {
+				t.Errorf("DoContext = %v, %v", v, err)
+			}
+		}()
+	}
+	for g.InFlight() == 0 {
+		time.Sleep(time.Millisecond)
+	}
+	time.Sleep(10 * time.Millisecond)
+	close(release)
+	wg.Wait()
+
+	if n := calls.Load(); n != 1 {
+		t.Fatalf("fn called %d times", n)
+	}
+}
+
+func TestDoContextCancel(t *testing.T) {
+	var g Group
+	release := make(chan struct{})
+	finished := make(chan struct{})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() {
+		time.Sleep(10 * time.Millisecond)
+		cancel()
+	}()
+	_, err, _ := g.DoContext(ctx, "key", func() (interface{}, error) {
+		<-release
+		close(finished)
+		return "bar", nil
+	})
+	if err != context.Canceled {
+		t.Fatalf("expected canceled, got %v", err)
+	}
+
+	// 共享的 fn 没有被取消，其他调用方仍然可以等到它的结果
+	time.AfterFunc(10*time.Millisecond, func() { close(release) })
+	v, err, _ := g.DoContext(context.Background(), "key", func() (interface{}, error) {
+		return "other", nil
+	})
+	<-finished
+	if err != nil || v != "bar" {
+		t.Fatalf("DoContext = %v, %v", v, err)
+	}
+}
+
+// 等到有 n 个调用方在等待 key 的结果
+func waitDups(g *Group, key string, n int) {
+	for {
+		g.mu.Lock()
+		c := g.m[key]
+		ready := c != nil && c.dups == n
+		g.mu.Unlock()
+		if ready {
+			return
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestDoPanic(t *testing.T) {
+	var g Group
+	release := make(chan struct{})
+
+	// 执行 fn 的调用方重新 panic
+	leader := make(chan interface{}, 1)
+	go func() {
+		defer func() { leader <- recover() }()
+		g.Do("key", func() (interface{}, error) {
+			<-release
+			panic("boom")
+		})
+	}()
+	for g.InFlight() == 0 {
+		time.Sleep(time.Millisecond)
+	}
+
+	// 其他调用方得到 PanicError，不会永远阻塞
+	const n = 3
+	errs := make(chan error, n)
+	for i := 0; i < n; i++ {
+		go func() {
+			_, err, _ := g.Do("key", func() (interface{}, error) { return nil, nil })
+			errs <- err
+		}()
+	}
+	waitDups(&g, "key", n)
+	close(release)
+
+	for i := 0; i < n; i++ {
+		select {
+		case err := <-errs:
+			var perr *PanicError
+			if !errors.As(err, &perr) || perr.Value != "boom" || !strings.Contains(string(perr.Stack), "TestDoPanic") {
+				t.Fatalf("expected PanicError with stack, got %v", err)
+			}
+		case <-time.After(time.Second):
+			t.Fatal("waiter deadlocked")
+		}
+	}
+	if r, ok := (<-leader).(*PanicError); !ok || r.Value != "boom" {
+		t.Fatalf("leader should re-panic with PanicError, got %v", r)
+	}
+
+	// 之后的调用不受影响
+	if v, err, _ := g.Do("key", func() (interface{}, error) { return "bar", nil }); v != "bar" || err != nil {
+		t.Fatalf("Do after panic = %v, %v", v, err)
+	}
+}
+
+func TestDoGoexit(t *testing.T) {
+	var g Group
+	release := make(chan struct{})
+
+	exited := make(chan bool, 1)
+	go func() {
+		normal := false
+		defer func() { exited <- normal }()
+		g.Do("key", func() (interface{}, error) {
+			<-release
+			runtime.Goexit()
+			return nil, nil
+		})
+		normal = true
+	}()
+	for g.InFlight() == 0 {
+		time.Sleep(time.Millisecond)
+	}
+
+	errs := make(chan error, 1)
+	go func() {
+		_, err, _ := g.Do("key", func() (interface{}, error) { return nil, nil })
+		errs <- err
+	}()
+	waitDups(&g, "key", 1)
+	close(release)
+
+	select {
+	case err := <-errs:
+		if err != ErrGoexit {
+			t.Fatalf("expected ErrGoexit, got %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("waiter deadlocked")
+	}
+	if <-exited {
+		t.Fatal("leader goroutine should keep exiting")
+	}
+}
+
+// DoChan 和 DoContext 中 fn 在单独的 goroutine 执行，panic 只作为错误返回
+func TestDoChanPanic(t *testing.T) {
+	var g Group
+	release := make(chan struct{})
+	ch := g.DoChan("key", func() (interface{}, error) {
+		<-release
+		panic(errors.New("boom"))
+	})
+
+	errs := make(chan error, 1)
+	go func() {
+		_, err, _ := g.DoContext(context.Background(), "key", func() (interface{}, error) { return nil, nil })
+		errs <- err
+	}()
+	waitDups(&g, "key", 1)
+	close(release)
+
+	for _, err := range []error{(<-ch).Err, <-errs} {
+		var perr *PanicError
+		if !errors.As(err, &perr) || err.Error() != "singleflight: fn panicked: boom" {
+			t.Fatalf("expected PanicError, got %v", err)
+		}
+	}
+
+	ch = g.DoChan("exit", func() (interface{}, error) {
+		runtime.Goexit()
+		return nil, nil
+	})
+	select {
+	case r := <-ch:
+		if r.Err != ErrGoexit {
+			t.Fatalf("expected ErrGoexit, got %v", r.Err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("DoChan deadlocked after Goexit")
+	}
+}
@@ -1,14 +1,20 @@
 package skycache
 
 import (
+	"context"
 	"errors"
+	"fmt"
 	"log"
+	"math/rand"
+	"runtime/debug"
+	"skycache/bloom"
 	"skycache/singleflight"
 	pb "skycache/skycachepb"
 	"sync"
+	"time"
 )
 
-// Getter接口
+// Getter接口，key 不存在时应返回 ErrNotFound
 type Getter interface {
 	Get(key string) ([]byte, error)
 }
@@ -20,13 +26,140 @@ func (f GetterFunc) Get(key string) ([]byte, error) {
 	return f(key)
 }
 
+// 可以感知 context 的 Getter，Group 会优先调用 GetContext
+type ContextGetter interface {
+	Getter
+	GetContext(ctx context.Context, key string) ([]byte, error)
+}
+
+// 类似于 GetterFunc，同时实现 Getter 和 ContextGetter
+type ContextGetterFunc func(ctx context.Context, key string) ([]byte, error)
+
+func (f ContextGetterFunc) Get(key string) ([]byte, error) {
+	return f(context.Background(), key)
+}
+
+func (f ContextGetterFunc) GetContext(ctx context.Context, key string) ([]byte, error) {
+	return f(ctx, key)
+}
+
 // 一个 Group，描述一种资源，这种资源分布式的保存在多个节点中
 type Group struct {
 	name      string
-	getter    Getter //应对 cache 未命中
-	mainCache cache
+	getter    Getter              //应对 cache 未命中
+	mainCache cache               //保存 所属节点为自己的数据
+	hotCache  cache               //保存 从远程节点获取的热点数据的副本，避免单个节点成为热点
+	negCache  cache               //保存 确认不存在的 key，避免反复访问 db
 	peers     PeerPicker          //应对 远程节点
 	loader    *singleflight.Group //应对 缓存击穿
+	ttl       time.Duration       //默认过期时间，为 0 则永不过期
+
+	stats groupStats //统计信息
+
+	hotCacheRatio float64       //hotCache 占 cacheBytes 的比例
+	hotSampleRate float64       //从远程节点获取的数据，放入 hotCache 的概率
+	negativeTTL   time.Duration //negCache 中记录的过期时间，为 0 则不使用 negCache
+
+	bloom     *bloom.Filter //不为 nil 时，调用 getter 前先检查 key 是否可能存在
+	bloomKeys KeyEnumerator //用于填充 bloom
+
+	replicas     int  //每个 key 的所属节点数，默认为 1
+	writeThrough bool //写入时同时写入其他所属节点
+
+	leaseTTL time.Duration //加载租约的时长，为 0 则不使用租约
+	leases   leaseTable    //作为租约节点时发放的租约
+
+	store  Store        //write-through 写入的数据源，为 nil 则不写入
+	behind *writeBehind //write-behind 队列，不为 nil 时优先于 store
+
+	refreshAhead float64  //记录存在超过 ttl 的这个比例后提前刷新，为 0 则不提前刷新
+	refreshing   sync.Map //正在后台刷新的 key
+}
+
+const (
+	defaultHotCacheRatio = 1.0 / 8
+	defaultHotSampleRate = 0.1
+)
+
+// NewGroup 的可选配置
+type GroupOption func(*Group)
+
+// 设置 group 内记录的默认过期时间
+func WithTTL(ttl time.Duration) GroupOption {
+	return func(g *Group) {
+		g.ttl = ttl
+	}
+}
+
+// 设置缓存的淘汰策略，默认为 LRU
+func WithEvictionPolicy(policy EvictionPolicy) GroupOption {
+	return func(g *Group) {
+		g.mainCache.policy = policy
+	}
+}
+
+// 设置后台清理过期记录的间隔
+func WithJanitorInterval(interval time.Duration) GroupOption {
+	return func(g *Group) {
+		g.mainCache.janitorInterval = interval
+	}
+}
+
+// 设置 hotCache 占 cacheBytes 的比例，默认为 1/8，为 0 则不使用 hotCache
+// ratio 必须在 [0, 1) 之间，否则 mainCache 没有容量
+func WithHotCacheRatio(ratio float64) GroupOption {
+	if !(ratio >= 0 && ratio < 1) {
+		panic("hot cache ratio must be in [0, 1)")
+	}
+	return func(g *Group) {
+		g.hotCacheRatio = ratio
+	}
+}
+
+// 设置从远程节点获取的数据放入 hotCache 的概率，默认为 0.1
+func WithHotSampleRate(rate float64) GroupOption {
+	return func(g *Group) {
+		g.hotSampleRate = rate
+	}
+}
+
+// 缓存 Getter 返回 ErrNotFound 的 key，记录在 ttl 后过期，最多占用 maxBytes，
+// 有自己的容量，不占用 cacheBytes；默认不开启
+func WithNegativeCache(ttl time.Duration, maxBytes int64) GroupOption {
+	return func(g *Group) {
+		g.negativeTTL = ttl
+		g.negCache.cacheBytes = maxBytes
+	}
+}
+
+// 枚举数据源中所有的 key，每个 key 调用一次 add
+type KeyEnumerator func(add func(key string)) error
+
+// 在调用 getter 之前先查询 filter，一定不存在的 key 直接返回 ErrNotFound。
+// 创建 group 时使用 keys 填充 filter，之后通过 Set 写入的 key 也会加入 filter
+func WithBloomFilter(filter *bloom.Filter, keys KeyEnumerator) GroupOption {
+	return func(g *Group) {
+		g.bloom = filter
+		g.bloomKeys = keys
+	}
+}
+
+// 每个 key 有 n 个所属节点(哈希环上的前 n 个节点)，需要 PeerPicker 实现 ReplicaPicker
+// 第一个所属节点请求失败时，依次尝试其他所属节点，都失败才从本地加载；
+// writeThrough 为 true 时，Set 会同时写入所有所属节点
+func WithReplicas(n int, writeThrough bool) GroupOption {
+	return func(g *Group) {
+		g.replicas = n
+		g.writeThrough = writeThrough
+	}
+}
+
+// 开启集群级别的加载租约，需要 PeerPicker 实现 LeasePicker，详见 lease.go
+// 所属节点不可用时，整个集群只有一个节点从数据源加载，ttl 是持有者加载的最长时间
+func WithLoadLease(ttl time.Duration) GroupOption {
+	return func(g *Group) {
+		g.leaseTTL = ttl
+	}
 }
 
 var (
@@ -35,11 +168,7 @@ var (
 )
 
 // 创建新 group
-func NewGroup(name string, cacheBytes int64, getter Getter) *Group {
-	if getter == nil {
-		panic("nil getter")
-	}
-
+func NewGroup(name string, cacheBytes int64, getter Getter, opts ...GroupOption) *Group {
 	mu.Lock()
 	defer mu.Unlock()
 
@@ -47,13 +176,44 @@ func NewGroup(name string, cacheBytes int64, getter Getter) *Group {
 		panic("repeat group name")
 	}
 
+	g := newGroup(name, cacheBytes, getter, opts...)
+	groups[name] = g
+	return g
+}
+
+// 创建 group 但不注册到 groups 中
+func newGroup(name string, cacheBytes int64, getter Getter, opts ...GroupOption) *Group {
+	if getter == nil {
+		panic("nil getter")
+	}
+
 	g := &Group{
-		name:      name,
-		getter:    getter,
-		mainCache: cache{cacheBytes: cacheBytes},
-		loader:    &singleflight.Group{},
+		name:          name,
+		getter:        getter,
+		loader:        &singleflight.Group{},
+		replicas:      1,
+		hotCacheRatio: defaultHotCacheRatio,
+		hotSampleRate: defaultHotSampleRate,
+	}
+	for _, opt := range opts {
+		opt(g)
+	}
+
+	// hotCache 的容量从 cacheBytes 中划出，两者使用相同的淘汰策略
+	hotBytes := int64(float64(cacheBytes) * g.hotCacheRatio)
+	g.mainCache.cacheBytes = cacheBytes - hotBytes
+	g.hotCache.cacheBytes = hotBytes
+	g.hotCache.policy = g.mainCache.policy
+	g.hotCache.janitorInterval = g.mainCache.janitorInterval
+	g.negCache.janitorInterval = g.mainCache.janitorInterval
+
+	// filter 不完整时会把存在的 key 当作不存在，填充失败则不使用
+	if g.bloom != nil && g.bloomKeys != nil {
+		if err := g.bloomKeys(g.bloom.Add); err != nil {
+			log.Printf("[Cache %s] failed to populate bloom filter, disabled: %v", name, err)
+			g.bloom = nil
+		}
 	}
-	groups[name] = g
 	return g
 }
 
@@ -65,19 +225,53 @@ func GetGroup(name string) *Group {
 }
 
 // 用户获取(和被动添加) key-value
-func (g *Group) Get(key string) (ByteView, error) {
+// ctx 结束时立即返回，但共享的加载会继续进行，结果仍会写入 cache
+func (g *Group) Get(ctx context.Context, key string) (ByteView, error) {
 	if key == "" {
 		return ByteView{}, errors.New("key is must")
 	}
 
+	g.stats.gets.Add(1)
 	//尝试从 cache 中获取
-	if v, ok := g.mainCache.get(key); ok {
+	e, stale, ok := g.lookupMain(key)
+	if ok && !stale {
 		log.Printf("[Cache %s] hits\n", g.name)
+		g.stats.cacheHits.Add(1)
+		return e.value, nil
+	}
+	if v, ok := g.hotCache.get(key); ok {
+		log.Printf("[Cache %s] hot hits\n", g.name)
+		g.stats.cacheHits.Add(1)
 		return v, nil
 	}
+	if g.negativeHit(key) {
+		return ByteView{}, fmt.Errorf("%s: %w", key, ErrNotFound)
+	}
 
+	if stale {
+		return g.loadStale(ctx, key, e)
+	}
 	//尝试另外两种方式
-	return g.load(key)
+	return g.load(ctx, key)
+}
+
+// 返回 mainCache 或 hotCache 的统计信息
+func (g *Group) CacheStats(which CacheType) CacheStats {
+	switch which {
+	case MainCache:
+		return g.mainCache.stats()
+	case HotCache:
+		return g.hotCache.stats()
+	case NegativeCache:
+		return g.negCache.stats()
+	default:
+		return CacheStats{}
+	}
+}
+
+// 返回 group 使用的 bloom filter，可以序列化后发送给其他节点，没有则返回 nil
+func (g *Group) BloomFilter() *bloom.Filter {
+	return g.bloom
 }
 
 // RegisterPeers registers a PeerPicker for choosing remote peer
@@ -88,56 +282,327 @@ func (g *Group) RegisterPeers(peers PeerPicker) {
 	g.peers = peers
 }
 
-func (g *Group) load(key string) (value ByteView, err error) {
+func (g *Group) load(ctx context.Context, key string) (ByteView, error) {
+	g.stats.loads.Add(1)
+	return g.doLoad(ctx, key)
+}
+
+// 不计入 Loads 的加载，后台刷新也使用它
+func (g *Group) doLoad(ctx context.Context, key string) (ByteView, error) {
 	//当未命中 cache 时，同一时刻多个相同 key 的请求只会发起一次 db 访问
-	view, err := g.loader.Do(key, func() (interface{}, error) {
+	//共享的加载使用第一个调用方的截止时间，但不受其取消的影响
+	view, err, shared := g.loader.DoContext(ctx, key, func() (interface{}, error) {
+		defer logPanic(key)
+		lctx, cancel := detach(ctx)
+		defer cancel()
+		g.stats.loadsDeduped.Add(1)
 		if g.peers != nil {
-			if peer, ok := g.peers.PickPeer(key); ok {
-				if value, err = g.getFromPeer(peer, key); err == nil {
+			if peer, ok := g.pickReader(lctx, key); ok {
+				value, err := g.getFromPeer(lctx, peer, key)
+				if err == nil {
+					g.stats.peerLoads.Add(1)
+					g.populateHotCache(key, value)
 					return value, nil
 				}
+				// 所属节点确认 key 不存在，不需要再从本地加载
+				if errors.Is(err, ErrNotFound) {
+					g.populateNegCache(key)
+					return ByteView{}, err
+				}
+				g.stats.peerErrors.Add(1)
 				log.Println("[GeeCache] Failed to get from peer", err)
+				if value, err := g.getFromReplicas(lctx, peer, key); err == nil {
+					return value, nil
+				}
 			}
 		}
-		return g.getLocally(key)
+		return g.loadWithLease(lctx, key)
 	})
+	if shared {
+		g.stats.loadsShared.Add(1)
+	}
 	if err != nil {
-		return ByteView{}, nil
+		return ByteView{}, err
 	}
 	return view.(ByteView), nil
 }
 
-func (g *Group) getFromPeer(peer PeerGetter, key string) (ByteView, error) {
+// 在加载函数中 defer 调用，getter panic 时记录堆栈后继续 panic，由 singleflight 转为错误返回
+// 只在执行加载的 goroutine 中记录一次，等待的调用方只得到错误
+func logPanic(key string) {
+	if r := recover(); r != nil {
+		log.Printf("[GeeCache] load %s panicked: %v\n%s", key, r, debug.Stack())
+		panic(r)
+	}
+}
+
+// 返回一个不会随 ctx 取消的 context，但保留 ctx 的截止时间，以便传递给远程节点
+func detach(ctx context.Context) (context.Context, context.CancelFunc) {
+	dctx := context.WithoutCancel(ctx)
+	if deadline, ok := ctx.Deadline(); ok {
+		return context.WithDeadline(dctx, deadline)
+	}
+	return dctx, func() {}
+}
+
+func (g *Group) getFromPeer(ctx context.Context, peer PeerGetter, key string) (ByteView, error) {
 	req := &pb.Request{
 		Group: g.name,
 		Key:   key,
 	}
 	resp := &pb.Response{}
 
-	err := peer.Get(req, resp)
+	err := peer.Get(ctx, req, resp)
 	if err != nil {
 		return ByteView{}, err
 	}
+	// 自定义的 PeerGetter 可能只设置了 NotFound
+	if resp.NotFound {
+		return ByteView{}, fmt.Errorf("%s: %w", key, ErrNotFound)
+	}
 	return ByteView{b: resp.Value}, nil
 }
 
+// ctx 中携带的标记，表示请求是其他节点发来的
+type peerRequestKey struct{}
+
+// 处理其他节点发来的读请求，不再按负载转发，否则可能在节点之间来回转发
+func withPeerRequest(ctx context.Context) context.Context {
+	return context.WithValue(ctx, peerRequestKey{}, true)
+}
+
+// 读请求选择的节点，PeerPicker 实现了 ReadPicker 时可能不是所属节点
+func (g *Group) pickReader(ctx context.Context, key string) (PeerGetter, bool) {
+	if rp, ok := g.peers.(ReadPicker); ok && ctx.Value(peerRequestKey{}) == nil {
+		return rp.PickReader(key)
+	}
+	return g.peers.PickPeer(key)
+}
+
+// 所属节点 failed 请求失败后，依次尝试 key 的其他所属节点
+func (g *Group) getFromReplicas(ctx context.Context, failed PeerGetter, key string) (ByteView, error) {
+	rp, ok := g.peers.(ReplicaPicker)
+	if !ok || g.replicas <= 1 {
+		return ByteView{}, ErrNotFound
+	}
+
+	err := error(ErrNotFound)
+	for _, peer := range rp.PickReplicas(key, g.replicas) {
+		if peer == failed || !allowPeer(peer) {
+			continue
+		}
+		var value ByteView
+		if value, err = g.getFromPeer(ctx, peer, key); err == nil {
+			g.stats.peerLoads.Add(1)
+			g.populateHotCache(key, value)
+			return value, nil
+		}
+		g.stats.peerErrors.Add(1)
+		log.Println("[GeeCache] Failed to get from replica", err)
+		if errors.Is(err, ErrNotFound) {
+			break
+		}
+	}
+	return ByteView{}, err
+}
+
 // 调用 getter 从用户处获取源数据
-func (g *Group) getLocally(key string) (ByteView, error) {
-	bytes, err := g.getter.Get(key)
+func (g *Group) getLocally(ctx context.Context, key string) (ByteView, error) {
+	if value, err, ok := g.loadPending(key); ok {
+		return value, err
+	}
+	if g.mayNotExist(key) {
+		return ByteView{}, fmt.Errorf("%s: %w", key, ErrNotFound)
+	}
+
+	var bytes []byte
+	var err error
+	if cg, ok := g.getter.(ContextGetter); ok {
+		bytes, err = cg.GetContext(ctx, key)
+	} else {
+		bytes, err = g.getter.Get(key)
+	}
 	if err != nil {
+		g.stats.localLoadErrs.Add(1)
+		if errors.Is(err, ErrNotFound) {
+			g.populateNegCache(key)
+		}
 		return ByteView{}, err
 	}
+	g.stats.localLoads.Add(1)
 
 	value := ByteView{b: cloneByte(bytes)}
-	g.polulateCache(key, value)
+	g.populateLoaded(ctx, key, value)
 	return value, nil
 }
 
 func (g *Group) polulateCache(key string, value ByteView) {
-	g.mainCache.set(key, value)
+	g.mainCache.set(key, value, g.ttl)
+}
+
+// 从远程节点获取的数据，抽样一部分放入 hotCache，热点数据更容易被选中
+func (g *Group) populateHotCache(key string, value ByteView) {
+	if g.hotCache.cacheBytes > 0 && rand.Float64() < g.hotSampleRate {
+		g.hotCache.set(key, value, g.ttl)
+	}
+}
+
+// bloom filter 确认 key 一定不存在
+func (g *Group) mayNotExist(key string) bool {
+	if g.bloom == nil || g.bloom.Test(key) {
+		return false
+	}
+	g.stats.bloomRejects.Add(1)
+	return true
+}
+
+// 只有 Getter 确认不存在(ErrNotFound)的 key 才会放入 negCache，其他错误可能只是暂时的
+func (g *Group) populateNegCache(key string) {
+	if g.negativeTTL > 0 && g.negCache.cacheBytes > 0 {
+		g.negCache.set(key, ByteView{}, g.negativeTTL)
+	}
+}
+
+func (g *Group) negativeHit(key string) bool {
+	if g.negativeTTL <= 0 {
+		return false
+	}
+	if _, ok := g.negCache.get(key); ok {
+		log.Printf("[Cache %s] negative hits\n", g.name)
+		g.stats.negativeHits.Add(1)
+		return true
+	}
+	return false
+}
+
+// 主动添加数据，使用默认过期时间
+func (g *Group) Set(ctx context.Context, key string, value ByteView) error {
+	return g.SetWithTTL(ctx, key, value, g.ttl)
 }
 
-// 主动添加数据
-func (g *Group) Set(key string, value ByteView) {
-	g.mainCache.set(key, value)
+// 主动添加数据，并指定过期时间，ttl <= 0 表示永不过期
+// 写入会被转发到 key 的所属节点，由所属节点通知其他节点删除旧的副本
+func (g *Group) SetWithTTL(ctx context.Context, key string, value ByteView, ttl time.Duration) error {
+	if key == "" {
+		return errors.New("key is must")
+	}
+	if g.bloom != nil {
+		g.bloom.Add(key)
+	}
+
+	if g.peers != nil {
+		if peer, ok := g.peers.PickPeer(key); ok {
+			g.invalidate(key)
+			return peer.Set(ctx, &pb.SetRequest{
+				Group: g.name,
+				Key:   key,
+				Value: value.ByteSlice(),
+				Ttl:   int64(ttl),
+			})
+		}
+	}
+	return g.setAsOwner(ctx, key, value, ttl)
+}
+
+// 删除数据，同样转发到 key 的所属节点，再由所属节点广播
+func (g *Group) Remove(ctx context.Context, key string) error {
+	if key == "" {
+		return errors.New("key is must")
+	}
+
+	if g.peers != nil {
+		if peer, ok := g.peers.PickPeer(key); ok {
+			g.invalidate(key)
+			return peer.Remove(ctx, &pb.Request{Group: g.name, Key: key})
+		}
+	}
+	return g.removeAsOwner(ctx, key)
+}
+
+// 作为所属节点写入，并广播失效消息
+// 失效消息会删除其他所属节点上的旧值，因此之后再写入副本
+// 写入数据源失败时不修改缓存
+func (g *Group) setAsOwner(ctx context.Context, key string, value ByteView, ttl time.Duration) error {
+	if err := g.persist(ctx, StoreOp{Key: key, Value: value.ByteSlice()}); err != nil {
+		return err
+	}
+	g.setAsReplica(key, value, ttl)
+	g.broadcastInvalidate(ctx, key)
+	g.writeReplicas(ctx, key, value, ttl)
+	return nil
+}
+
+// 作为副本写入，只写入本地，不再广播
+func (g *Group) setAsReplica(key string, value ByteView, ttl time.Duration) {
+	if g.bloom != nil {
+		g.bloom.Add(key)
+	}
+	g.negCache.remove(key)
+	g.mainCache.set(key, value, ttl)
+}
+
+// 把写入同步到其他所属节点，失败的节点只能等待下次加载
+func (g *Group) writeReplicas(ctx context.Context, key string, value ByteView, ttl time.Duration) {
+	rp, ok := g.peers.(ReplicaPicker)
+	if !ok || !g.writeThrough || g.replicas <= 1 {
+		return
+	}
+
+	var wg sync.WaitGroup
+	for _, peer := range rp.PickReplicas(key, g.replicas) {
+		if !allowPeer(peer) {
+			continue
+		}
+		wg.Add(1)
+		go func(peer PeerGetter) {
+			defer wg.Done()
+			err := peer.Set(ctx, &pb.SetRequest{
+				Group:   g.name,
+				Key:     key,
+				Value:   value.ByteSlice(),
+				Ttl:     int64(ttl),
+				Replica: true,
+			})
+			if err != nil {
+				log.Printf("[Cache %s] failed to write %s to replica: %v", g.name, key, err)
+			}
+		}(peer)
+	}
+	wg.Wait()
+}
+
+// 作为所属节点删除，并广播失效消息
+func (g *Group) removeAsOwner(ctx context.Context, key string) error {
+	if err := g.persist(ctx, StoreOp{Key: key, Delete: true}); err != nil {
+		return err
+	}
+	g.invalidate(key)
+	g.broadcastInvalidate(ctx, key)
+	return nil
+}
+
+// 只删除本地的副本
+func (g *Group) invalidate(key string) {
+	g.mainCache.remove(key)
+	g.hotCache.remove(key)
+	g.negCache.remove(key)
+}
+
+// 通知其他所有节点删除 key 的副本，失败的节点只能等待副本过期
+func (g *Group) broadcastInvalidate(ctx context.Context, key string) {
+	if g.peers == nil {
+		return
+	}
+
+	var wg sync.WaitGroup
+	for _, peer := range g.peers.AllPeers() {
+		wg.Add(1)
+		go func(peer PeerGetter) {
+			defer wg.Done()
+			if err := peer.Invalidate(ctx, &pb.Request{Group: g.name, Key: key}); err != nil {
+				log.Printf("[Cache %s] failed to invalidate %s on peer: %v", g.name, key, err)
+			}
+		}(peer)
+	}
+	wg.Wait()
 }
@@ -1,10 +1,19 @@
 package skycache
 
 import (
+	"bytes"
+	"context"
+	"errors"
 	"fmt"
 	"log"
 	"reflect"
+	"skycache/bloom"
+	"skycache/singleflight"
+	pb "skycache/skycachepb"
+	"strings"
+	"sync"
 	"testing"
+	"time"
 )
 
 func TestGetter(t *testing.T) {
@@ -41,15 +50,476 @@ func TestGet(t *testing.T) {
 		}))
 
 	for k, v := range db {
-		if view, err := gee.Get(k); err != nil || view.String() != v {
+		if view, err := gee.Get(context.Background(), k); err != nil || view.String() != v {
 			t.Fatal("failed to get value of Tom")
 		} // load from callback function
-		if _, err := gee.Get(k); err != nil || loadCounts[k] > 1 {
+		if _, err := gee.Get(context.Background(), k); err != nil || loadCounts[k] > 1 {
 			t.Fatalf("cache %s miss", k)
 		} // cache hit
 	}
 
-	if view, err := gee.Get("unknown"); err == nil {
+	if view, err := gee.Get(context.Background(), "unknown"); err == nil {
 		t.Fatalf("the value of unknow should be empty, but %s got", view)
 	}
 }
+
+func TestGroupTTL(t *testing.T) {
+	loads := 0
+	g := NewGroup("ttl_scores", 2<<10, GetterFunc(
+		func(key string) ([]byte, error) {
+			loads++
+			return []byte(db[key]), nil
+		}), WithTTL(20*time.Millisecond))
+
+	if _, err := g.Get(context.Background(), "Tom"); err != nil || loads != 1 {
+		t.Fatalf("failed to load Tom, loads %d, err %v", loads, err)
+	}
+	if _, err := g.Get(context.Background(), "Tom"); err != nil || loads != 1 {
+		t.Fatalf("Tom should hit the cache before expiration")
+	}
+
+	// 过期后重新加载
+	time.Sleep(30 * time.Millisecond)
+	if _, err := g.Get(context.Background(), "Tom"); err != nil || loads != 2 {
+		t.Fatalf("Tom should be reloaded after expiration, loads %d", loads)
+	}
+
+	// 单独指定的 ttl 覆盖默认值
+	g.SetWithTTL(context.Background(), "Jack", ByteView{b: []byte("100")}, time.Hour)
+	time.Sleep(30 * time.Millisecond)
+	if v, err := g.Get(context.Background(), "Jack"); err != nil || v.String() != "100" {
+		t.Fatalf("Jack should not expire, got %s", v)
+	}
+}
+
+func TestJanitor(t *testing.T) {
+	g := NewGroup("janitor_scores", 2<<10, GetterFunc(
+		func(key string) ([]byte, error) {
+			return []byte(db[key]), nil
+		}), WithTTL(10*time.Millisecond), WithJanitorInterval(5*time.Millisecond))
+
+	g.Set(context.Background(), "Tom", ByteView{b: []byte(db["Tom"])})
+	if g.mainCache.bytes() == 0 {
+		t.Fatalf("cache should not be empty after Set")
+	}
+
+	// 不调用 Get，由 janitor 回收过期记录
+	time.Sleep(50 * time.Millisecond)
+	if n := g.mainCache.bytes(); n != 0 {
+		t.Fatalf("janitor should reclaim expired bytes, but %d bytes left", n)
+	}
+
+	// Close 之后 janitor 退出，过期记录只在访问时删除
+	if err := g.Close(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	g.Set(context.Background(), "Tom", ByteView{b: []byte(db["Tom"])})
+	time.Sleep(50 * time.Millisecond)
+	if g.mainCache.bytes() == 0 {
+		t.Fatal("janitor should stop after Close")
+	}
+}
+
+func TestEvictionPolicy(t *testing.T) {
+	for _, policy := range []EvictionPolicy{LRU, LFU, FIFO, ARC, TinyLFU} {
+		g := NewGroup("policy_"+policy.String(), 2<<10, GetterFunc(
+			func(key string) ([]byte, error) {
+				return []byte(db[key]), nil
+			}), WithEvictionPolicy(policy))
+
+		for k, v := range db {
+			if view, err := g.Get(context.Background(), k); err != nil || view.String() != v {
+				t.Fatalf("[%s] failed to get value of %s", policy, k)
+			}
+			if _, ok := g.mainCache.get(k); !ok {
+				t.Fatalf("[%s] %s should be cached", policy, k)
+			}
+		}
+	}
+}
+
+type batchGetter struct {
+	calls int
+}
+
+func (b *batchGetter) Get(key string) ([]byte, error) {
+	return nil, fmt.Errorf("Get should not be called")
+}
+
+func (b *batchGetter) GetMulti(keys []string) (map[string][]byte, error) {
+	b.calls++
+	values := make(map[string][]byte, len(keys))
+	for _, k := range keys {
+		if v, ok := db[k]; ok {
+			values[k] = []byte(v)
+		}
+	}
+	return values, nil
+}
+
+func TestGetMultiBatchGetter(t *testing.T) {
+	getter := &batchGetter{}
+	g := NewGroup("batch_scores", 2<<10, getter)
+
+	views, err := g.GetMulti(context.Background(), []string{"Tom", "Jack", "Sam"})
+	if err != nil || len(views) != 3 || getter.calls != 1 {
+		t.Fatalf("GetMulti should load all keys in one call, calls %d, err %v", getter.calls, err)
+	}
+
+	// 已经缓存，不再调用 getter
+	if _, err := g.GetMulti(context.Background(), []string{"Tom", "Sam"}); err != nil || getter.calls != 1 {
+		t.Fatalf("GetMulti should hit the cache, calls %d", getter.calls)
+	}
+	if v, err := g.Get(context.Background(), "Jack"); err != nil || v.String() != db["Jack"] {
+		t.Fatalf("Jack should be cached by GetMulti")
+	}
+}
+
+func TestStats(t *testing.T) {
+	// 容量只够保存一条记录
+	g := NewGroup("stats_scores", 10, GetterFunc(
+		func(key string) ([]byte, error) {
+			if v, ok := db[key]; ok {
+				return []byte(v), nil
+			}
+			return nil, fmt.Errorf("%s not exist", key)
+		}), WithHotCacheRatio(0))
+
+	g.Get(context.Background(), "Tom")
+	g.Get(context.Background(), "Tom")
+	g.Get(context.Background(), "Jack") // 淘汰 Tom
+	g.Get(context.Background(), "unknown")
+
+	expect := Stats{
+		Gets:          4,
+		CacheHits:     1,
+		Loads:         3,
+		LoadsDeduped:  3,
+		LocalLoads:    2,
+		LocalLoadErrs: 1,
+		Evictions:     1,
+	}
+	if stats := g.Stats(); stats != expect {
+		t.Fatalf("stats error, expected %+v, got %+v", expect, stats)
+	}
+
+	main := g.CacheStats(MainCache)
+	if main.Items != 1 || main.Bytes != int64(len("Jack")+len(db["Jack"])) {
+		t.Fatalf("mainCache should only contain Jack, got %+v", main)
+	}
+}
+
+// 并发请求同一个 key 只加载一次，其他调用方共享结果
+func TestSharedLoads(t *testing.T) {
+	release := make(chan struct{})
+	g := newGroup("shared_scores", 2<<10, GetterFunc(
+		func(key string) ([]byte, error) {
+			<-release
+			return []byte(db[key]), nil
+		}))
+
+	const n = 5
+	var wg sync.WaitGroup
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if v, err := g.Get(context.Background(), "Tom"); err != nil || v.String() != "630" {
+				t.Errorf("Get = %v, %v", v, err)
+			}
+		}()
+	}
+	for g.Stats().Loads != n {
+		time.Sleep(time.Millisecond)
+	}
+	time.Sleep(10 * time.Millisecond) //等所有调用方进入 singleflight
+	close(release)
+	wg.Wait()
+
+	if stats := g.Stats(); stats.LoadsDeduped != 1 || stats.LoadsShared != n {
+		t.Fatalf("expected 1 load shared by %d callers, got %+v", n, stats)
+	}
+}
+
+// getter panic 时 Get 返回错误，不影响之后的请求
+func TestGetterPanic(t *testing.T) {
+	g := newGroup("panic_scores", 2<<10, GetterFunc(
+		func(key string) ([]byte, error) {
+			if key == "bad" {
+				panic("getter bug")
+			}
+			return []byte(db[key]), nil
+		}))
+
+	var perr *singleflight.PanicError
+	if _, err := g.Get(context.Background(), "bad"); !errors.As(err, &perr) || perr.Value != "getter bug" {
+		t.Fatalf("expected PanicError, got %v", err)
+	}
+	if v, err := g.Get(context.Background(), "Tom"); err != nil || v.String() != "630" {
+		t.Fatalf("Get after panic = %v, %v", v, err)
+	}
+
+	// 多个调用方共享同一次 panic 的加载时，堆栈只记录一次
+	release := make(chan struct{})
+	shared := newGroup("shared_panic_scores", 2<<10, GetterFunc(
+		func(key string) ([]byte, error) {
+			<-release
+			panic("getter bug")
+		}))
+	var buf bytes.Buffer
+	defer log.SetOutput(log.Writer())
+	log.SetOutput(&buf)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 3; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			var perr *singleflight.PanicError
+			if _, err := shared.Get(context.Background(), "bad"); !errors.As(err, &perr) {
+				t.Errorf("expected PanicError, got %v", err)
+			}
+		}()
+	}
+	time.Sleep(20 * time.Millisecond)
+	close(release)
+	wg.Wait()
+	if n := strings.Count(buf.String(), "panicked"); n != 1 {
+		t.Fatalf("panic should be logged once, got %d", n)
+	}
+}
+
+func TestGetContextTimeout(t *testing.T) {
+	release := make(chan struct{})
+	g := newGroup("ctx_scores", 2<<10, GetterFunc(
+		func(key string) ([]byte, error) {
+			<-release
+			return []byte(db[key]), nil
+		}))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if _, err := g.Get(ctx, "Tom"); err != context.DeadlineExceeded {
+		t.Fatalf("expected deadline exceeded, got %v", err)
+	}
+
+	// 放弃等待的调用方不会取消共享的加载，加载完成后仍然会写入 cache
+	close(release)
+	deadline := time.Now().Add(time.Second)
+	for {
+		if v, ok := g.mainCache.get("Tom"); ok {
+			if v.String() != db["Tom"] {
+				t.Fatalf("unexpected value %s", v)
+			}
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("shared load was cancelled")
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestNegativeCache(t *testing.T) {
+	loads := make(map[string]int)
+	g := newGroup("negative_scores", 2<<10, GetterFunc(
+		func(key string) ([]byte, error) {
+			loads[key]++
+			if key == "flaky" {
+				return nil, errors.New("db is down")
+			}
+			if v, ok := db[key]; ok {
+				return []byte(v), nil
+			}
+			return nil, fmt.Errorf("%s not exist: %w", key, ErrNotFound)
+		}), WithNegativeCache(50*time.Millisecond, 1<<10))
+
+	for i := 0; i < 3; i++ {
+		if _, err := g.Get(context.Background(), "unknown"); !errors.Is(err, ErrNotFound) {
+			t.Fatalf("expected ErrNotFound, got %v", err)
+		}
+	}
+	if loads["unknown"] != 1 || g.Stats().NegativeHits != 2 {
+		t.Fatalf("unknown should be loaded once, got %d loads, stats %+v", loads["unknown"], g.Stats())
+	}
+
+	// 其他错误可能只是暂时的，不缓存
+	g.Get(context.Background(), "flaky")
+	g.Get(context.Background(), "flaky")
+	if loads["flaky"] != 2 {
+		t.Fatalf("flaky should not be cached, got %d loads", loads["flaky"])
+	}
+
+	// 过期后重新加载
+	time.Sleep(60 * time.Millisecond)
+	g.Get(context.Background(), "unknown")
+	if loads["unknown"] != 2 {
+		t.Fatalf("negative entry should expire, got %d loads", loads["unknown"])
+	}
+
+	// 写入后不再返回 ErrNotFound
+	g.Set(context.Background(), "unknown", ByteView{b: []byte("1")})
+	if v, err := g.Get(context.Background(), "unknown"); err != nil || v.String() != "1" {
+		t.Fatalf("get after set failed: %v %v", v, err)
+	}
+}
+
+func TestBloomFilter(t *testing.T) {
+	loads := make(map[string]int)
+	g := newGroup("bloom_scores", 2<<10, GetterFunc(
+		func(key string) ([]byte, error) {
+			loads[key]++
+			if v, ok := db[key]; ok {
+				return []byte(v), nil
+			}
+			return nil, ErrNotFound
+		}), WithBloomFilter(bloom.New(100, 0.01), func(add func(key string)) error {
+		for k := range db {
+			add(k)
+		}
+		return nil
+	}))
+
+	for k, v := range db {
+		if view, err := g.Get(context.Background(), k); err != nil || view.String() != v {
+			t.Fatalf("failed to get %s: %v", k, err)
+		}
+	}
+
+	// 一定不存在的 key 不会调用 getter
+	if _, err := g.Get(context.Background(), "unknown"); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("expected ErrNotFound, got %v", err)
+	}
+	if loads["unknown"] != 0 || g.Stats().BloomRejects != 1 {
+		t.Fatalf("unknown should be rejected by bloom filter, got %d loads", loads["unknown"])
+	}
+
+	// Set 之后 key 加入 filter
+	g.Set(context.Background(), "Lily", ByteView{b: []byte("99")})
+	if !g.BloomFilter().Test("Lily") {
+		t.Fatal("Lily should be added to bloom filter")
+	}
+
+	// 填充失败时不使用 filter
+	g2 := newGroup("bloom_failed_scores", 2<<10, GetterFunc(
+		func(key string) ([]byte, error) {
+			return []byte(db[key]), nil
+		}), WithBloomFilter(bloom.New(100, 0.01), func(add func(key string)) error {
+		return errors.New("db is down")
+	}))
+	if g2.BloomFilter() != nil {
+		t.Fatal("bloom filter should be disabled")
+	}
+}
+
+// 只实现 Get 的 PeerGetter，用于模拟远程节点
+type fakePeer struct {
+	PeerGetter
+	value string
+	err   error
+	calls int
+}
+
+func (p *fakePeer) Get(ctx context.Context, in *pb.Request, out *pb.Response) error {
+	p.calls++
+	if p.err != nil {
+		return p.err
+	}
+	out.Value = []byte(p.value)
+	return nil
+}
+
+type fakePicker struct {
+	replicas []PeerGetter
+}
+
+func (p *fakePicker) PickPeer(key string) (PeerGetter, bool) { return p.replicas[0], true }
+func (p *fakePicker) AllPeers() []PeerGetter                 { return p.replicas }
+func (p *fakePicker) PickReplicas(key string, n int) []PeerGetter {
+	return p.replicas[:min(n, len(p.replicas))]
+}
+
+func TestReplicaFallback(t *testing.T) {
+	primary := &fakePeer{err: errors.New("connection refused")}
+	secondary := &fakePeer{value: "from replica"}
+	locals := 0
+	g := newGroup("replica_scores", 2<<10, GetterFunc(
+		func(key string) ([]byte, error) {
+			locals++
+			return []byte("from db"), nil
+		}), WithReplicas(2, false), WithHotCacheRatio(0))
+	g.RegisterPeers(&fakePicker{replicas: []PeerGetter{primary, secondary}})
+
+	if v, err := g.Get(context.Background(), "Tom"); err != nil || v.String() != "from replica" {
+		t.Fatalf("expected value from replica, got %s, %v", v, err)
+	}
+	if primary.calls != 1 || secondary.calls != 1 || locals != 0 {
+		t.Fatalf("unexpected calls: primary %d, secondary %d, local %d", primary.calls, secondary.calls, locals)
+	}
+
+	// 所有所属节点都失败时从本地加载
+	secondary.err = errors.New("timeout")
+	if v, err := g.Get(context.Background(), "Jack"); err != nil || v.String() != "from db" {
+		t.Fatalf("expected value from db, got %s, %v", v, err)
+	}
+}
+
+// 不存在的 key 返回整批的 ErrNotFound，key 为 broken 时返回其他错误，结果中仍有加载成功的部分
+type partialBatchGetter struct{}
+
+func (partialBatchGetter) Get(key string) ([]byte, error) {
+	values, err := partialBatchGetter{}.GetMulti([]string{key})
+	if v, ok := values[key]; ok {
+		return v, nil
+	}
+	return nil, err
+}
+
+func (partialBatchGetter) GetMulti(keys []string) (map[string][]byte, error) {
+	values := make(map[string][]byte, len(keys))
+	var err error
+	for _, k := range keys {
+		if v, ok := db[k]; ok {
+			values[k] = []byte(v)
+		} else if k == "broken" {
+			err = errors.New("db is down")
+		} else if err == nil {
+			err = fmt.Errorf("%s: %w", k, ErrNotFound)
+		}
+	}
+	return values, err
+}
+
+func TestGetMultiPeerNotFound(t *testing.T) {
+	_, nodes := startHTTPCluster(t, "multi_notfound_scores", 2, partialBatchGetter{},
+		WithNegativeCache(time.Minute, 2<<10), WithHotCacheRatio(0))
+	ctx := context.Background()
+
+	// 整批的 ErrNotFound 只让缺少的 key 被当作不存在，加载成功的值照常返回
+	for i, g := range nodes {
+		views, err := g.GetMulti(ctx, []string{"Tom", "Jack", "Sam", "unknown"})
+		if err != nil || len(views) != len(db) {
+			t.Fatalf("node %d got %v, %v", i, views, err)
+		}
+		for k := range db {
+			if v, err := g.Get(ctx, k); err != nil || v.String() != db[k] {
+				t.Fatalf("node %d: %s should not be negative cached, got %s, %v", i, k, v, err)
+			}
+		}
+		if _, ok := g.negCache.get("unknown"); !ok {
+			t.Fatalf("node %d should negative cache unknown", i)
+		}
+	}
+
+	// 其他错误时仍然返回加载成功的部分，失败的 key 不会被当作不存在
+	for i, g := range nodes {
+		g.mainCache.remove("Tom")
+		views, err := g.GetMulti(ctx, []string{"Tom", "broken"})
+		if err == nil || views["Tom"].String() != db["Tom"] {
+			t.Fatalf("node %d should get Tom and the load error, got %v, %v", i, views, err)
+		}
+		if _, ok := g.negCache.get("broken"); ok {
+			t.Fatalf("node %d should not negative cache broken", i)
+		}
+	}
+}
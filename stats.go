@@ -0,0 +1,67 @@
+package skycache
+
+import "sync/atomic"
+
+// Group 的统计信息，所有计数都是累计值
+type Stats struct {
+	Gets           int64 //Get 请求的 key 数，包括来自其他节点的
+	CacheHits      int64 //mainCache 或 hotCache 命中
+	NegativeHits   int64 //negCache 命中，直接返回 ErrNotFound
+	Loads          int64 //未命中，需要加载 (Gets - CacheHits - NegativeHits)
+	LoadsDeduped   int64 //经过 singleflight 去重后，实际执行的加载
+	LoadsShared    int64 //与其他调用方共享了同一次加载结果的调用
+	PeerLoads      int64 //从远程节点获取成功
+	PeerErrors     int64 //从远程节点获取失败
+	LocalLoads     int64 //调用 getter 成功
+	LocalLoadErrs  int64 //调用 getter 失败
+	BloomRejects   int64 //bloom filter 确认不存在，没有调用 getter
+	LeaseWaits     int64 //等到了其他节点在租约内加载的结果，没有调用 getter
+	Refreshes      int64 //接近过期时在后台发起的刷新
+	StaleHits      int64 //重新加载失败，返回了已过期的旧值
+	ServerRequests int64 //来自其他节点的请求
+	Evictions      int64 //因容量不足被淘汰的记录，包括 mainCache 和 hotCache
+	Expirations    int64 //因过期被清除的记录，包括 mainCache 和 hotCache
+}
+
+// Group 内部使用的原子计数器
+type groupStats struct {
+	gets           atomic.Int64
+	cacheHits      atomic.Int64
+	negativeHits   atomic.Int64
+	loads          atomic.Int64
+	loadsDeduped   atomic.Int64
+	loadsShared    atomic.Int64
+	peerLoads      atomic.Int64
+	peerErrors     atomic.Int64
+	localLoads     atomic.Int64
+	localLoadErrs  atomic.Int64
+	bloomRejects   atomic.Int64
+	leaseWaits     atomic.Int64
+	refreshes      atomic.Int64
+	staleHits      atomic.Int64
+	serverRequests atomic.Int64
+}
+
+// 返回当前统计信息的快照
+func (g *Group) Stats() Stats {
+	main, hot := g.mainCache.stats(), g.hotCache.stats()
+	return Stats{
+		Gets:           g.stats.gets.Load(),
+		CacheHits:      g.stats.cacheHits.Load(),
+		NegativeHits:   g.stats.negativeHits.Load(),
+		Loads:          g.stats.loads.Load(),
+		LoadsDeduped:   g.stats.loadsDeduped.Load(),
+		LoadsShared:    g.stats.loadsShared.Load(),
+		PeerLoads:      g.stats.peerLoads.Load(),
+		PeerErrors:     g.stats.peerErrors.Load(),
+		LocalLoads:     g.stats.localLoads.Load(),
+		LocalLoadErrs:  g.stats.localLoadErrs.Load(),
+		BloomRejects:   g.stats.bloomRejects.Load(),
+		LeaseWaits:     g.stats.leaseWaits.Load(),
+		Refreshes:      g.stats.refreshes.Load(),
+		StaleHits:      g.stats.staleHits.Load(),
+		ServerRequests: g.stats.serverRequests.Load(),
+		Evictions:      main.Evictions + hot.Evictions,
+		Expirations:    main.Expirations + hot.Expirations,
+	}
+}
@@ -0,0 +1,342 @@
+package skycache
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"slices"
+	"sync"
+	"time"
+)
+
+// 数据源的写接口，和 Getter 配合使用
+//
+// 开启后，Set 和 Remove 在 key 的所属节点上同时写入数据源：
+//   - write-through (WithStore)：先同步写入数据源，成功后再更新缓存，失败时返回错误
+//   - write-behind (WithWriteBehind)：写入放入有界队列后立即更新缓存，由后台批量写入，
+//     失败会重试，仍然失败时通过 OnError 通知；Close 时写完队列中剩余的写入
+type Store interface {
+	Put(ctx context.Context, key string, value []byte) error
+	// key 不存在时应返回 nil
+	Delete(ctx context.Context, key string) error
+}
+
+// 可选接口，Store 实现后 write-behind 一次写入一批，例如使用事务或批量接口
+type BatchStore interface {
+	Store
+	Write(ctx context.Context, ops []StoreOp) error
+}
+
+// 一次写入或删除
+type StoreOp struct {
+	Key    string
+	Value  []byte
+	Delete bool
+}
+
+func (op StoreOp) apply(ctx context.Context, store Store) error {
+	if op.Delete {
+		return store.Delete(ctx, op.Key)
+	}
+	return store.Put(ctx, op.Key, op.Value)
+}
+
+// write-behind 的配置，为 0 的字段使用默认值
+type WriteBehind struct {
+	QueueSize     int                         //队列大小，队列满时 Set 等待，默认 1024
+	BatchSize     int                         //每批最多写入的数量，默认 100
+	FlushInterval time.Duration               //不满一批时最长多久写入一次，默认 1s
+	MaxRetries    int                         //失败后的重试次数，默认 3，为负数则不重试
+	RetryBackoff  time.Duration               //第一次重试前的等待时间，之后每次翻倍，默认 100ms
+	OnError       func(op StoreOp, err error) //重试后仍然失败时调用，为 nil 则只记录日志
+}
+
+// Group 已经关闭，不再接受写入
+var ErrClosed = errors.New("skycache: group is closed")
+
+// 先同步写入 store，成功后再更新缓存
+func WithStore(store Store) GroupOption {
+	return func(g *Group) {
+		g.store = store
+	}
+}
+
+// 写入放入队列后立即更新缓存，由后台批量写入 store，需要在退出前调用 Group.Close
+func WithWriteBehind(store Store, cfg WriteBehind) GroupOption {
+	return func(g *Group) {
+		g.behind = newWriteBehind(store, cfg)
+	}
+}
+
+// 在所属节点上把写入同步到数据源
+func (g *Group) persist(ctx context.Context, op StoreOp) error {
+	if g.behind != nil {
+		return g.behind.enqueue(ctx, op)
+	}
+	if g.store != nil {
+		return op.apply(ctx, g.store)
+	}
+	return nil
+}
+
+// 还没有写入数据源的 key，从队列中读取，避免从数据源加载到旧值
+func (g *Group) loadPending(key string) (ByteView, error, bool) {
+	if g.behind == nil {
+		return ByteView{}, nil, false
+	}
+	op, ok := g.behind.lookup(key)
+	if !ok {
+		return ByteView{}, nil, false
+	}
+	if op.Delete {
+		return ByteView{}, fmt.Errorf("%s: %w", key, ErrNotFound), true
+	}
+	value := ByteView{b: cloneByte(op.Value)}
+	g.polulateCache(key, value)
+	return value, nil, true
+}
+
+// 停止后台任务，把 write-behind 队列中剩余的写入全部写入数据源
+// ctx 结束时不再等待，返回 ctx.Err()，剩余的写入仍会在后台继续
+func (g *Group) Close(ctx context.Context) error {
+	g.mainCache.close()
+	g.hotCache.close()
+	g.negCache.close()
+	if g.behind == nil {
+		return nil
+	}
+	return g.behind.close(ctx)
+}
+
+type pendingOp struct {
+	op  StoreOp
+	seq uint64
+}
+
+type writeBehind struct {
+	store Store
+	cfg   WriteBehind
+	queue chan pendingOp
+
+	mu      sync.Mutex //保护 closed
+	closed  bool
+	senders sync.WaitGroup //正在 enqueue 的调用方，后台停止前等待它们返回
+
+	pmu     sync.Mutex //保护 seq 和 pending
+	seq     uint64
+	pending map[string][]pendingOp //还没有写入数据源的写入，按 seq 排序，加载时使用最后一个
+
+	done      chan struct{} //通知后台停止
+	stopped   chan struct{} //后台已经写完并退出
+	closeOnce sync.Once
+}
+
+func newWriteBehind(store Store, cfg WriteBehind) *writeBehind {
+	if cfg.QueueSize <= 0 {
+		cfg.QueueSize = 1024
+	}
+	if cfg.BatchSize <= 0 {
+		cfg.BatchSize = 100
+	}
+	if cfg.FlushInterval <= 0 {
+		cfg.FlushInterval = time.Second
+	}
+	if cfg.MaxRetries == 0 {
+		cfg.MaxRetries = 3
+	}
+	if cfg.RetryBackoff <= 0 {
+		cfg.RetryBackoff = 100 * time.Millisecond
+	}
+
+	w := &writeBehind{
+		store:   store,
+		cfg:     cfg,
+		queue:   make(chan pendingOp, cfg.QueueSize),
+		pending: make(map[string][]pendingOp),
+		done:    make(chan struct{}),
+		stopped: make(chan struct{}),
+	}
+	go w.run()
+	return w
+}
+
+// 放入队列，队列满时等待，直到有空位、ctx 结束或者 close
+func (w *writeBehind) enqueue(ctx context.Context, op StoreOp) error {
+	w.mu.Lock()
+	if w.closed {
+		w.mu.Unlock()
+		return ErrClosed
+	}
+	w.senders.Add(1)
+	w.mu.Unlock()
+	defer w.senders.Done()
+
+	p := w.track(op)
+	select {
+	case w.queue <- p:
+		return nil
+	case <-ctx.Done():
+		w.forget(p)
+		return ctx.Err()
+	case <-w.done:
+		w.forget(p)
+		return ErrClosed
+	}
+}
+
+// 记录 key 的一次写入
+func (w *writeBehind) track(op StoreOp) pendingOp {
+	w.pmu.Lock()
+	defer w.pmu.Unlock()
+	w.seq++
+	p := pendingOp{op: op, seq: w.seq}
+	w.pending[op.Key] = append(w.pending[op.Key], p)
+	return p
+}
+
+// 返回 key 还没有写入数据源的最新写入
+func (w *writeBehind) lookup(key string) (StoreOp, bool) {
+	w.pmu.Lock()
+	defer w.pmu.Unlock()
+	ops := w.pending[key]
+	if len(ops) == 0 {
+		return StoreOp{}, false
+	}
+	return ops[len(ops)-1].op, true
+}
+
+// 写入完成或放弃后从 pending 中删除，同一个 key 更早的写入仍然保留
+func (w *writeBehind) forget(p pendingOp) {
+	w.pmu.Lock()
+	defer w.pmu.Unlock()
+	ops := w.pending[p.op.Key]
+	for i, q := range ops {
+		if q.seq == p.seq {
+			ops = slices.Delete(ops, i, i+1)
+			break
+		}
+	}
+	if len(ops) == 0 {
+		delete(w.pending, p.op.Key)
+	} else {
+		w.pending[p.op.Key] = ops
+	}
+}
+
+// 不再接受新的写入，等待队列中的写入完成，ctx 结束时不再等待
+func (w *writeBehind) close(ctx context.Context) error {
+	w.closeOnce.Do(func() {
+		w.mu.Lock()
+		w.closed = true
+		w.mu.Unlock()
+		// 等待中的 enqueue 收到 done 后返回 ErrClosed
+		close(w.done)
+	})
+
+	select {
+	case <-w.stopped:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (w *writeBehind) run() {
+	defer close(w.stopped)
+	ticker := time.NewTicker(w.cfg.FlushInterval)
+	defer ticker.Stop()
+
+	batch := make([]pendingOp, 0, w.cfg.BatchSize)
+	add := func(p pendingOp) {
+		batch = append(batch, p)
+		if len(batch) >= w.cfg.BatchSize {
+			w.flush(batch)
+			batch = batch[:0]
+		}
+	}
+
+	for {
+		select {
+		case p := <-w.queue:
+			add(p)
+		case <-ticker.C:
+			if len(batch) > 0 {
+				w.flush(batch)
+				batch = batch[:0]
+			}
+		case <-w.done:
+			// 等 enqueue 都返回后不会再有新的写入，写完队列中剩余的
+			w.senders.Wait()
+			for {
+				select {
+				case p := <-w.queue:
+					add(p)
+				default:
+					if len(batch) > 0 {
+						w.flush(batch)
+					}
+					return
+				}
+			}
+		}
+	}
+}
+
+// 写入一批，同一个 key 只保留最后一次写入
+func (w *writeBehind) flush(batch []pendingOp) {
+	latest := make(map[string]int, len(batch))
+	for i, p := range batch {
+		latest[p.op.Key] = i
+	}
+	var merged, superseded []pendingOp
+	var ops []StoreOp
+	for i, p := range batch {
+		if latest[p.op.Key] == i {
+			merged = append(merged, p)
+			ops = append(ops, p.op)
+		} else {
+			superseded = append(superseded, p)
+		}
+	}
+	// 被覆盖的写入随最后一次写入一起完成
+	defer func() {
+		for _, p := range superseded {
+			w.forget(p)
+		}
+	}()
+
+	if bs, ok := w.store.(BatchStore); ok {
+		err := w.retry(func() error { return bs.Write(context.Background(), ops) })
+		for _, p := range merged {
+			w.finish(p, err)
+		}
+		return
+	}
+	for _, p := range merged {
+		w.finish(p, w.retry(func() error { return p.op.apply(context.Background(), w.store) }))
+	}
+}
+
+func (w *writeBehind) retry(fn func() error) error {
+	backoff := w.cfg.RetryBackoff
+	err := fn()
+	for i := 0; err != nil && i < w.cfg.MaxRetries; i++ {
+		time.Sleep(backoff)
+		backoff *= 2
+		err = fn()
+	}
+	return err
+}
+
+func (w *writeBehind) finish(p pendingOp, err error) {
+	w.forget(p)
+	if err == nil {
+		return
+	}
+	if w.cfg.OnError != nil {
+		w.cfg.OnError(p.op, err)
+		return
+	}
+	log.Printf("[GeeCache] write-behind failed to write %s: %v", p.op.Key, err)
+}
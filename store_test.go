@@ -0,0 +1,260 @@
+package skycache
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+)
+
+// 记录写入的 Store，fail 不为 nil 时所有写入都返回 fail
+type memStore struct {
+	mu      sync.Mutex
+	data    map[string]string
+	batches [][]StoreOp
+	writes  int
+	fail    error
+	block   chan struct{} //不为 nil 时，写入前等待 block 关闭
+}
+
+func newMemStore() *memStore {
+	return &memStore{data: make(map[string]string)}
+}
+
+func (s *memStore) Put(ctx context.Context, key string, value []byte) error {
+	return s.Write(ctx, []StoreOp{{Key: key, Value: value}})
+}
+
+func (s *memStore) Delete(ctx context.Context, key string) error {
+	return s.Write(ctx, []StoreOp{{Key: key, Delete: true}})
+}
+
+func (s *memStore) Write(ctx context.Context, ops []StoreOp) error {
+	if s.block != nil {
+		<-s.block
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.writes++
+	if s.fail != nil {
+		return s.fail
+	}
+	s.batches = append(s.batches, ops)
+	for _, op := range ops {
+		if op.Delete {
+			delete(s.data, op.Key)
+		} else {
+			s.data[op.Key] = string(op.Value)
+		}
+	}
+	return nil
+}
+
+func (s *memStore) get(key string) (string, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	v, ok := s.data[key]
+	return v, ok
+}
+
+func TestWriteThroughStore(t *testing.T) {
+	store := newMemStore()
+	g := newGroup("store_scores", 2<<10, GetterFunc(
+		func(key string) ([]byte, error) {
+			return []byte(db[key]), nil
+		}), WithStore(store))
+	ctx := context.Background()
+
+	if err := g.Set(ctx, "Tom", ByteView{b: []byte("100")}); err != nil {
+		t.Fatalf("set Tom failed: %v", err)
+	}
+	if v, _ := store.get("Tom"); v != "100" {
+		t.Fatalf("store should hold 100, got %q", v)
+	}
+
+	// 写入数据源失败时返回错误，缓存保持旧值
+	store.fail = errors.New("db is down")
+	if err := g.Set(ctx, "Tom", ByteView{b: []byte("200")}); !errors.Is(err, store.fail) {
+		t.Fatalf("expected store error, got %v", err)
+	}
+	if v, _ := g.mainCache.get("Tom"); v.String() != "100" {
+		t.Fatalf("cache should keep 100 after a failed write, got %s", v)
+	}
+	if err := g.Remove(ctx, "Tom"); err == nil {
+		t.Fatal("remove should fail when the store fails")
+	}
+
+	store.fail = nil
+	if err := g.Remove(ctx, "Tom"); err != nil {
+		t.Fatalf("remove Tom failed: %v", err)
+	}
+	if _, ok := store.get("Tom"); ok {
+		t.Fatal("Tom should be deleted from the store")
+	}
+}
+
+func TestWriteBehind(t *testing.T) {
+	store := newMemStore()
+	g := newGroup("write_behind_scores", 2<<10, GetterFunc(
+		func(key string) ([]byte, error) {
+			return []byte(db[key]), nil
+		}), WithWriteBehind(store, WriteBehind{BatchSize: 3, FlushInterval: time.Hour}))
+	ctx := context.Background()
+
+	// 凑满一批后写入，同一个 key 只写入最后一次
+	for _, kv := range [][2]string{{"Tom", "1"}, {"Tom", "2"}, {"Jack", "3"}} {
+		if err := g.Set(ctx, kv[0], ByteView{b: []byte(kv[1])}); err != nil {
+			t.Fatalf("set %s failed: %v", kv[0], err)
+		}
+	}
+	deadline := time.Now().Add(time.Second)
+	for {
+		store.mu.Lock()
+		n := len(store.batches)
+		store.mu.Unlock()
+		if n > 0 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("batch was not flushed")
+		}
+		time.Sleep(time.Millisecond)
+	}
+	if len(store.batches[0]) != 2 {
+		t.Fatalf("batch should be coalesced to 2 ops, got %v", store.batches[0])
+	}
+	if v, _ := store.get("Tom"); v != "2" {
+		t.Fatalf("store should hold the last write, got %q", v)
+	}
+
+	// 还没有写入数据源时，缓存被淘汰后仍然读到新值，而不是数据源中的旧值
+	if err := g.Set(ctx, "Sam", ByteView{b: []byte("999")}); err != nil {
+		t.Fatalf("set Sam failed: %v", err)
+	}
+	g.invalidate("Sam")
+	if v, err := g.Get(ctx, "Sam"); err != nil || v.String() != "999" {
+		t.Fatalf("pending write should be visible, got %s, %v", v, err)
+	}
+	if err := g.Remove(ctx, "Jack"); err != nil {
+		t.Fatalf("remove Jack failed: %v", err)
+	}
+	if _, err := g.Get(ctx, "Jack"); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("pending delete should be visible, got %v", err)
+	}
+
+	// Close 写完剩余的写入，之后不再接受写入
+	if err := g.Close(ctx); err != nil {
+		t.Fatalf("close failed: %v", err)
+	}
+	if v, _ := store.get("Sam"); v != "999" {
+		t.Fatalf("close should flush Sam, got %q", v)
+	}
+	if _, ok := store.get("Jack"); ok {
+		t.Fatal("close should flush the delete of Jack")
+	}
+	if err := g.Set(ctx, "Tom", ByteView{b: []byte("3")}); !errors.Is(err, ErrClosed) {
+		t.Fatalf("expected ErrClosed, got %v", err)
+	}
+}
+
+func TestWriteBehindErrors(t *testing.T) {
+	store := newMemStore()
+	store.fail = errors.New("db is down")
+	store.block = make(chan struct{})
+	var mu sync.Mutex
+	var failed []StoreOp
+	g := newGroup("write_behind_failed_scores", 2<<10, GetterFunc(
+		func(key string) ([]byte, error) {
+			return []byte(db[key]), nil
+		}), WithWriteBehind(store, WriteBehind{
+		QueueSize:    1,
+		BatchSize:    1,
+		MaxRetries:   2,
+		RetryBackoff: time.Millisecond,
+		OnError: func(op StoreOp, err error) {
+			mu.Lock()
+			defer mu.Unlock()
+			failed = append(failed, op)
+		},
+	}))
+	ctx := context.Background()
+
+	// 第一个写入被后台取走并阻塞在 store 上，第二个占满队列，第三个等到超时
+	for _, key := range []string{"Tom", "Jack"} {
+		if err := g.Set(ctx, key, ByteView{b: []byte("1")}); err != nil {
+			t.Fatalf("set %s failed: %v", key, err)
+		}
+	}
+	tctx, cancel := context.WithTimeout(ctx, 20*time.Millisecond)
+	defer cancel()
+	if err := g.Set(tctx, "Sam", ByteView{b: []byte("1")}); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("set on a full queue should time out, got %v", err)
+	}
+	if _, ok := g.mainCache.get("Sam"); ok {
+		t.Fatal("cache should not be updated when the queue is full")
+	}
+
+	// 重试后仍然失败，通过 OnError 通知
+	close(store.block)
+	if err := g.Close(ctx); err != nil {
+		t.Fatalf("close failed: %v", err)
+	}
+	if len(failed) != 2 || failed[0].Key != "Tom" || failed[1].Key != "Jack" {
+		t.Fatalf("OnError should see Tom and Jack, got %v", failed)
+	}
+	if store.writes != 6 {
+		t.Fatalf("each op should be tried 3 times, got %d writes", store.writes)
+	}
+}
+
+func TestWriteBehindClose(t *testing.T) {
+	store := newMemStore()
+	store.block = make(chan struct{})
+	g := newGroup("write_behind_close_scores", 2<<10, GetterFunc(
+		func(key string) ([]byte, error) {
+			return []byte(db[key]), nil
+		}), WithWriteBehind(store, WriteBehind{QueueSize: 1, BatchSize: 1}))
+	ctx := context.Background()
+
+	// Tom=1 被后台取走并阻塞在 store 上，Tom=2 占满队列
+	for _, v := range []string{"1", "2"} {
+		if err := g.Set(ctx, "Tom", ByteView{b: []byte(v)}); err != nil {
+			t.Fatalf("set Tom=%s failed: %v", v, err)
+		}
+	}
+
+	// 放弃的写入不影响之前还在队列中的写入
+	tctx, cancel := context.WithTimeout(ctx, 10*time.Millisecond)
+	defer cancel()
+	if err := g.Set(tctx, "Tom", ByteView{b: []byte("3")}); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("set on a full queue should time out, got %v", err)
+	}
+	if op, ok := g.behind.lookup("Tom"); !ok || string(op.Value) != "2" {
+		t.Fatalf("pending Tom should still be 2, got %q, %v", op.Value, ok)
+	}
+
+	// 队列满时 Close 不会被等待中的 Set 卡住，等待中的 Set 得到 ErrClosed
+	blocked := make(chan error, 1)
+	go func() { blocked <- g.Set(ctx, "Jack", ByteView{b: []byte("1")}) }()
+	time.Sleep(10 * time.Millisecond)
+	cctx, ccancel := context.WithTimeout(ctx, 20*time.Millisecond)
+	defer ccancel()
+	if err := g.Close(cctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("close should give up at its deadline, got %v", err)
+	}
+	if err := <-blocked; !errors.Is(err, ErrClosed) {
+		t.Fatalf("blocked set should get ErrClosed, got %v", err)
+	}
+
+	close(store.block)
+	if err := g.Close(ctx); err != nil {
+		t.Fatalf("close failed: %v", err)
+	}
+	if v, _ := store.get("Tom"); v != "2" {
+		t.Fatalf("store should hold Tom=2, got %q", v)
+	}
+	if _, ok := g.behind.lookup("Tom"); ok {
+		t.Fatal("nothing should be pending after close")
+	}
+}
@@ -0,0 +1,81 @@
+package tinylfu
+
+import "hash/fnv"
+
+// count-min sketch，使用 4 行 4bit 的计数器估计 key 的访问频次
+// 累计增加 width*10 次后，所有计数器减半，使旧的热点逐渐冷却
+type sketch struct {
+	rows      [4][]byte //每个 byte 保存两个 4bit 计数器
+	mask      uint64
+	additions int
+	resetAt   int
+}
+
+func newSketch(width int) *sketch {
+	// 取 2 的幂，方便用掩码取模
+	w := 16
+	for w < width {
+		w <<= 1
+	}
+	s := &sketch{mask: uint64(w - 1), resetAt: w * 10}
+	for i := range s.rows {
+		s.rows[i] = make([]byte, w/2)
+	}
+	return s
+}
+
+func hashKey(key string) uint64 {
+	h := fnv.New64a()
+	h.Write([]byte(key))
+	return h.Sum64()
+}
+
+// 第 i 行使用的计数器下标，由同一个 hash 值派生
+func (s *sketch) index(h uint64, i int) uint64 {
+	x := h + uint64(i)*0x9e3779b97f4a7c15
+	x = (x ^ (x >> 30)) * 0xbf58476d1ce4e5b9
+	x = (x ^ (x >> 27)) * 0x94d049bb133111eb
+	x ^= x >> 31
+	return x & s.mask
+}
+
+func (s *sketch) get(row []byte, idx uint64) byte {
+	return (row[idx/2] >> ((idx & 1) * 4)) & 0x0f
+}
+
+func (s *sketch) increment(key string) {
+	h := hashKey(key)
+	for i := range s.rows {
+		idx := s.index(h, i)
+		if s.get(s.rows[i], idx) < 15 {
+			s.rows[i][idx/2] += 1 << ((idx & 1) * 4)
+		}
+	}
+
+	s.additions++
+	if s.additions >= s.resetAt {
+		s.reset()
+	}
+}
+
+// 估计频次，取各行中的最小值
+func (s *sketch) estimate(key string) byte {
+	h := hashKey(key)
+	min := byte(15)
+	for i := range s.rows {
+		if v := s.get(s.rows[i], s.index(h, i)); v < min {
+			min = v
+		}
+	}
+	return min
+}
+
+// 所有计数器减半
+func (s *sketch) reset() {
+	for i := range s.rows {
+		for j := range s.rows[i] {
+			s.rows[i][j] = (s.rows[i][j] >> 1) & 0x77
+		}
+	}
+	s.additions /= 2
+}
@@ -0,0 +1,213 @@
+package tinylfu
+
+import (
+	"container/list"
+	"skycache/eviction"
+	"time"
+)
+
+var _ eviction.Cache = (*Cache)(nil)
+
+const (
+	windowPercent    = 1  //window 占总容量的比例
+	protectedPercent = 80 //protected 占 main 的比例
+	avgEntryBytes    = 64 //估计的平均记录大小，用于确定 sketch 的宽度
+)
+
+// W-TinyLFU Cache，按字节计算容量
+//
+// 新记录先进入一个很小的 window lru，被 window 淘汰后成为候选者，
+// 只有当它的估计频次高于 main 中将被淘汰的记录时才会被接纳。
+// main 是一个分段 lru：probation 保存新接纳的记录，再次命中后晋升到 protected。
+// 扫描类的访问只会经过 window，很难挤掉 main 中的热点数据
+type Cache struct {
+	maxBytes     int64 //最大容量，为 0 则无上限
+	windowMax    int64
+	protectedMax int64
+	mainMax      int64
+	window       *segment
+	probation    *segment
+	protected    *segment
+	cache        map[string]*list.Element
+	freq         *sketch
+	OnEvicted    eviction.OnEvicted //回调函数，可选
+}
+
+type segment struct {
+	ll     *list.List //队首为最近访问
+	nBytes int64
+}
+
+type entry struct {
+	key    string
+	value  eviction.Value
+	size   int64
+	expire time.Time //过期时间，零值表示永不过期
+	seg    *segment  //所在的分段
+}
+
+func New(maxBytes int64, onEvicted eviction.OnEvicted) *Cache {
+	windowMax := maxBytes * windowPercent / 100
+	mainMax := maxBytes - windowMax
+	width := min(max(maxBytes/avgEntryBytes, 1024), 1<<20)
+
+	return &Cache{
+		maxBytes:     maxBytes,
+		windowMax:    windowMax,
+		mainMax:      mainMax,
+		protectedMax: mainMax * protectedPercent / 100,
+		window:       &segment{ll: list.New()},
+		probation:    &segment{ll: list.New()},
+		protected:    &segment{ll: list.New()},
+		cache:        make(map[string]*list.Element),
+		freq:         newSketch(int(width)),
+		OnEvicted:    onEvicted,
+	}
+}
+
+func (c *Cache) Get(key string) (eviction.Value, bool) {
+	c.freq.increment(key)
+
+	if e, ok := c.cache[key]; ok {
+		kv := e.Value.(*entry)
+		if eviction.IsExpired(kv.expire, time.Now()) {
+			c.removeElement(e, eviction.Expired)
+			return nil, false
+		}
+		c.touch(e)
+		return kv.value, true
+	}
+	return nil, false
+}
+
+func (c *Cache) Set(key string, value eviction.Value) {
+	c.SetWithTTL(key, value, 0)
+}
+
+func (c *Cache) SetWithTTL(key string, value eviction.Value, ttl time.Duration) {
+	c.freq.increment(key)
+	expire := eviction.Deadline(ttl)
+	size := int64(len(key) + value.Len())
+
+	if e, ok := c.cache[key]; ok {
+		kv := e.Value.(*entry)
+		kv.seg.nBytes += size - kv.size
+		kv.value, kv.size, kv.expire = value, size, expire
+		c.touch(e)
+	} else {
+		c.push(&entry{key: key, value: value, size: size, expire: expire}, c.window)
+	}
+	c.evict()
+}
+
+// 访问一次记录：probation 中的晋升到 protected，其余移到所在分段的队首
+func (c *Cache) touch(e *list.Element) {
+	kv := e.Value.(*entry)
+	if kv.seg == c.probation {
+		c.move(e, c.protected)
+		return
+	}
+	kv.seg.ll.MoveToFront(e)
+}
+
+// 把各个分段调整回容量之内
+func (c *Cache) evict() {
+	if c.maxBytes == 0 {
+		return
+	}
+
+	// protected 溢出的记录降级到 probation
+	for c.protected.nBytes > c.protectedMax && c.protected.ll.Len() > 0 {
+		c.move(c.protected.ll.Back(), c.probation)
+	}
+
+	// window 淘汰的记录作为候选者，尝试进入 main
+	for c.window.nBytes > c.windowMax && c.window.ll.Len() > 0 {
+		c.admit(c.window.ll.Back())
+	}
+
+	// 更新记录后 main 也可能溢出
+	for c.probation.nBytes+c.protected.nBytes > c.mainMax {
+		victim := c.victim()
+		if victim == nil {
+			break
+		}
+		c.removeElement(victim, eviction.Evicted)
+	}
+}
+
+// main 中下一个被淘汰的记录，优先淘汰 probation
+func (c *Cache) victim() *list.Element {
+	if e := c.probation.ll.Back(); e != nil {
+		return e
+	}
+	return c.protected.ll.Back()
+}
+
+// 候选者和 main 中的淘汰者比较频次，频次更高的留下
+func (c *Cache) admit(candidate *list.Element) {
+	kv := candidate.Value.(*entry)
+	if kv.size > c.mainMax {
+		c.removeElement(candidate, eviction.Evicted)
+		return
+	}
+
+	for c.probation.nBytes+c.protected.nBytes+kv.size > c.mainMax {
+		victim := c.victim()
+		if c.freq.estimate(kv.key) <= c.freq.estimate(victim.Value.(*entry).key) {
+			c.removeElement(candidate, eviction.Evicted)
+			return
+		}
+		c.removeElement(victim, eviction.Evicted)
+	}
+	c.move(candidate, c.probation)
+}
+
+func (c *Cache) push(kv *entry, seg *segment) {
+	kv.seg = seg
+	seg.nBytes += kv.size
+	c.cache[kv.key] = seg.ll.PushFront(kv)
+}
+
+func (c *Cache) move(e *list.Element, seg *segment) {
+	kv := e.Value.(*entry)
+	kv.seg.ll.Remove(e)
+	kv.seg.nBytes -= kv.size
+	c.push(kv, seg)
+}
+
+func (c *Cache) Remove(key string) {
+	if e, ok := c.cache[key]; ok {
+		c.removeElement(e, eviction.Removed)
+	}
+}
+
+func (c *Cache) RemoveExpired() int {
+	now := time.Now()
+	n := 0
+	for _, e := range c.cache {
+		if eviction.IsExpired(e.Value.(*entry).expire, now) {
+			c.removeElement(e, eviction.Expired)
+			n++
+		}
+	}
+	return n
+}
+
+func (c *Cache) removeElement(e *list.Element, reason eviction.EvictReason) {
+	kv := e.Value.(*entry)
+	kv.seg.ll.Remove(e)
+	kv.seg.nBytes -= kv.size
+	delete(c.cache, kv.key)
+	if c.OnEvicted != nil {
+		c.OnEvicted(kv.key, kv.value, reason)
+	}
+}
+
+func (c *Cache) Len() int {
+	return len(c.cache)
+}
+
+func (c *Cache) Bytes() int64 {
+	return c.window.nBytes + c.probation.nBytes + c.protected.nBytes
+}
@@ -0,0 +1,68 @@
+package tinylfu_test
+
+import (
+	"fmt"
+	"skycache/tinylfu"
+	"testing"
+	"time"
+)
+
+type String string
+
+func (s String) Len() int {
+	return len(s)
+}
+
+func TestGet(t *testing.T) {
+	c := tinylfu.New(0, nil)
+	c.Set("1", String("val1"))
+	if v, ok := c.Get("1"); !ok || string(v.(String)) != "val1" {
+		t.Fatalf("cache hit key 1 failed, expecetd val1, got %v", v)
+	}
+
+	if v, ok := c.Get("2"); ok {
+		t.Fatalf("cache hit key 2 failed, expected nil, got %v", v)
+	}
+}
+
+// 一次大范围的扫描不应该冲刷掉反复访问的热点数据
+func TestScanResistance(t *testing.T) {
+	c := tinylfu.New(int64(1000), nil)
+	hot := make([]string, 20)
+	for i := range hot {
+		hot[i] = fmt.Sprintf("hot%02d", i)
+		c.Set(hot[i], String("value"))
+	}
+	for round := 0; round < 5; round++ {
+		for _, k := range hot {
+			c.Get(k)
+		}
+	}
+
+	for i := 0; i < 1000; i++ {
+		c.Set(fmt.Sprintf("scan%04d", i), String("value"))
+	}
+
+	for _, k := range hot {
+		if _, ok := c.Get(k); !ok {
+			t.Fatalf("hot key %s was evicted by a scan", k)
+		}
+	}
+	if c.Bytes() > 1000 {
+		t.Fatalf("cache exceeds maxBytes, %d bytes", c.Bytes())
+	}
+}
+
+func TestSetWithTTL(t *testing.T) {
+	c := tinylfu.New(0, nil)
+	c.SetWithTTL("k1", String("val1"), 10*time.Millisecond)
+	c.Set("k2", String("val2"))
+
+	time.Sleep(20 * time.Millisecond)
+	if n := c.RemoveExpired(); n != 1 {
+		t.Fatalf("RemoveExpired should remove 1 entry, but %d removed", n)
+	}
+	if _, ok := c.Get("k2"); !ok || c.Len() != 1 {
+		t.Fatalf("k2 should never expire")
+	}
+}